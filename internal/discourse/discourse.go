@@ -2,94 +2,450 @@ package discourse
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/log"
+
+	"github.com/lszucs/github-to-discourse/internal/marker"
+	"github.com/lszucs/github-to-discourse/internal/retry"
 )
 
 const (
-	internalTestCategory  = 29
-	buildIssuesCat = 11
+	internalTestCategory = 29
+	buildIssuesCat       = 11
+	defaultBaseURL       = "https://discuss.bitrise.io"
 )
 
 var (
 	discourseAPIKey     = os.Getenv("DISCOURSE_API_KEY")
 	discourseAPIUser    = os.Getenv("DISCOURSE_API_USER")
 	discourseCategoryID int
-	topicTpl = `Original GitHub post: %s
-	
-	%s`
+	baseURL             string
+	replyTpl            = `**%s** commented on %s ([view on GitHub](%s)):
+
+%s`
 )
 
 func init() {
+	flag.StringVar(&baseURL, "discourse-url", defaultBaseURL, "--discourse-url=<url> (base URL of the discourse instance to migrate to)")
+	flag.IntVar(&discourseCategoryID, "discourse-category-id", internalTestCategory, "--discourse-category-id=<int> (discourse category to post topics to)")
+}
+
+// CheckCredentials tells whether the discourse API credentials are set. It is
+// not checked on init, so that packages using this one can be tested without them.
+func CheckCredentials() error {
 	if discourseAPIKey == "" {
-		fmt.Println("DISCOURSE_API_KEY empty")
-		os.Exit(1)
+		return fmt.Errorf("DISCOURSE_API_KEY empty")
 	}
-
 	if discourseAPIUser == "" {
-		fmt.Println("DISCOURSE_API_USER empty")
-		os.Exit(1)
+		return fmt.Errorf("DISCOURSE_API_USER empty")
 	}
+	return nil
+}
 
-	flag.IntVar(&discourseCategoryID, "discourse-category-id", internalTestCategory, "--discourse-category-id=<int> (discourse category to post topics to)")
+// CategoryID returns the discourse category topics are posted to by default.
+func CategoryID() int {
+	return discourseCategoryID
 }
 
-func PostTopic(title string, originURL, content string) (string, error) {
+// BaseURL returns the base URL of the discourse instance, without a trailing slash.
+func BaseURL() string {
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// PostTopic creates a topic with the given raw body, appending a migration
+// marker referencing the GitHub issue at originURL.
+func PostTopic(ctx context.Context, title, originURL, body string, categoryID int, tags []string) (string, error) {
 	message := map[string]interface{}{
-		"title": title,
-		"category": discourseCategoryID,
-		"raw": fmt.Sprintf(topicTpl, originURL, content),
+		"title":    title,
+		"category": categoryID,
+		"raw":      marker.Append(body, marker.Marker{IssueURL: originURL}),
+	}
+	if len(tags) > 0 {
+		message["tags"] = tags
 	}
 
-	payload, err := json.Marshal(message)
+	topicID, err := createPost(ctx, message, func() (int64, bool, error) {
+		url, err := FindTopic(ctx, originURL)
+		if err != nil || url == "" {
+			return 0, false, err
+		}
+		topicID, err := parseTopicID(url)
+		return topicID, err == nil, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return topicURL(topicID), nil
+}
+
+// Reply is a GitHub issue comment to be posted as a reply to the migrated topic.
+type Reply struct {
+	Author    string
+	CreatedAt time.Time
+	URL       string
+	Body      string
+}
+
+// Raw renders the raw body of the reply, quoting the original comment.
+func (r Reply) Raw() string {
+	quoted := "> " + strings.Replace(r.Body, "\n", "\n> ", -1)
+	return fmt.Sprintf(replyTpl, r.Author, r.CreatedAt.Format("2006-01-02 15:04 MST"), r.URL, quoted)
+}
+
+// PostReply posts the raw body as a reply to the topic at topicURL, appending
+// a migration marker referencing the GitHub comment at commentURL.
+func PostReply(ctx context.Context, topicURL, originURL, commentURL, body string) error {
+	topicID, err := parseTopicID(topicURL)
 	if err != nil {
-		return "", fmt.Errorf("could not marshal %s; reason: %s", message, err)
+		return err
 	}
 
-	queryStr := url.Values{
-		"api_key": []string{discourseAPIKey},
-		"api_username": []string{discourseAPIUser},
-	}.Encode()
-	url := fmt.Sprintf("https://discuss.bitrise.io/posts.json?%s", queryStr)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(payload))
+	message := map[string]interface{}{
+		"topic_id": topicID,
+		"raw":      marker.Append(body, marker.Marker{IssueURL: originURL, CommentURL: commentURL}),
+	}
+
+	_, err = createPost(ctx, message, func() (int64, bool, error) {
+		posted, err := PostedReplies(ctx, topicURL)
+		return topicID, posted[commentURL], err
+	})
+	return err
+}
+
+// PostedReplies returns the URLs of the GitHub comments already posted as
+// replies to the topic at topicURL.
+func PostedReplies(ctx context.Context, topicURL string) (map[string]bool, error) {
+	topicID, err := parseTopicID(topicURL)
 	if err != nil {
-		return "", fmt.Errorf("error posting payload %s: %s", payload, err)
+		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Warnf("warning: could not close response body: %s", err)
+	posted := map[string]bool{}
+	// discourse returns the raw posts of a topic in pages of 100, the page
+	// after the last one being empty
+	var last []byte
+	for page := 1; ; page++ {
+		raw, err := getBody(ctx, fmt.Sprintf("/raw/%d", topicID), url.Values{"page": {strconv.Itoa(page)}})
+		if err != nil {
+			return nil, fmt.Errorf("fetch raw topic %s page %d: %s", topicURL, page, err)
+		}
+		// instances ignoring the page parameter return the same posts again
+		if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(raw, last) {
+			return posted, nil
 		}
-	}()
+		last = raw
+
+		for _, m := range marker.FindAll(string(raw)) {
+			if m.CommentURL != "" {
+				posted[m.CommentURL] = true
+			}
+		}
+	}
+}
+
+// createPost creates a post, retrying it on network and server errors. As
+// the post may have been created despite those, exists is asked before each
+// retry to find the topic of a post created by an earlier attempt.
+func createPost(ctx context.Context, message map[string]interface{}, exists func() (int64, bool, error)) (int64, error) {
+	payload, err := json.Marshal(message)
 	if err != nil {
-		return "", fmt.Errorf("could not read response body: %s", err)
+		return 0, fmt.Errorf("could not marshal %s; reason: %s", message, err)
 	}
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("api error for payload %s; response body: %s", payload, body)
+
+	_, isTopic := message["title"]
+	for attempt := 0; ; attempt++ {
+		if isTopic {
+			if err := topicBudget.wait(ctx); err != nil {
+				return 0, err
+			}
+		}
+
+		resp, err := send(ctx, http.MethodPost, "/posts.json", nil, payload)
+		if err == nil && resp.code == http.StatusOK {
+			return parseCreatedPost(resp.body)
+		}
+		if err == nil && resp.code < http.StatusInternalServerError {
+			return 0, fmt.Errorf("api error for payload %s: %s; response body: %s", payload, resp.status, resp.body)
+		}
+		if err == nil {
+			err = fmt.Errorf("api error for payload %s: %s; response body: %s", payload, resp.status, resp.body)
+		}
+		if attempt >= maxRetries {
+			return 0, err
+		}
+
+		topicID, ok, cerr := exists()
+		if cerr != nil {
+			return 0, fmt.Errorf("%s; check whether the post was created: %s", err, cerr)
+		}
+		if ok {
+			log.Warnf("post was created despite error: %s", err)
+			return topicID, nil
+		}
+
+		wait := retry.Backoff(attempt)
+		log.Warnf("post failed, retrying in %s: %s", wait.Round(time.Second), err)
+		if err := retry.Sleep(ctx, wait); err != nil {
+			return 0, err
+		}
 	}
+}
 
-	decoder := json.NewDecoder(strings.NewReader(string(body)))
+func parseCreatedPost(body []byte) (int64, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
 	decoder.UseNumber()
 
 	var data map[string]interface{}
 	if err := decoder.Decode(&data); err != nil {
-		return "", fmt.Errorf("could not unmarshal response body %s; reason: %s", body, err)
+		return 0, fmt.Errorf("could not unmarshal response body %s; reason: %s", body, err)
 	}
 
 	topicID, err := data["topic_id"].(json.Number).Int64()
 	if err != nil {
-		return "", fmt.Errorf("could not unmarshal response body %s; reason: %s", body, err)
+		return 0, fmt.Errorf("could not unmarshal response body %s; reason: %s", body, err)
+	}
+
+	return topicID, nil
+}
+
+// Topic is a discourse topic as checked by verification.
+type Topic struct {
+	CategoryID   int
+	FirstPostRaw string
+}
+
+// GetTopic fetches the topic at topicURL along with the raw body of its
+// first post. It returns false if the topic does not exist.
+func GetTopic(ctx context.Context, topicURL string) (Topic, bool, error) {
+	topicID, err := parseTopicID(topicURL)
+	if err != nil {
+		return Topic{}, false, err
+	}
+
+	path := fmt.Sprintf("/t/%d.json", topicID)
+	resp, err := send(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return Topic{}, false, err
+	}
+	if resp.code == http.StatusNotFound {
+		return Topic{}, false, nil
+	}
+	if resp.code != http.StatusOK {
+		return Topic{}, false, fmt.Errorf("api error: GET %s: %s %s", path, resp.status, resp.body)
+	}
+
+	var data struct {
+		CategoryID int `json:"category_id"`
+		PostStream struct {
+			Posts []struct {
+				ID         int64 `json:"id"`
+				PostNumber int   `json:"post_number"`
+			} `json:"posts"`
+		} `json:"post_stream"`
+	}
+	if err := json.Unmarshal(resp.body, &data); err != nil {
+		return Topic{}, false, fmt.Errorf("could not unmarshal response body %s; reason: %s", resp.body, err)
+	}
+
+	t := Topic{CategoryID: data.CategoryID}
+	for _, p := range data.PostStream.Posts {
+		if p.PostNumber != 1 {
+			continue
+		}
+
+		var first post
+		if err := get(ctx, fmt.Sprintf("/posts/%d.json", p.ID), nil, &first); err != nil {
+			return Topic{}, false, fmt.Errorf("fetch post %d: %s", p.ID, err)
+		}
+		t.FirstPostRaw = first.Raw
+	}
+	return t, true, nil
+}
+
+// PageExists tells whether the page at pageURL exists, e.g. before pointing
+// a link to it. Pages of the discourse instance are requested through its
+// API, so that pages only its users can see are found too.
+func PageExists(ctx context.Context, pageURL string) (bool, error) {
+	var code int
+	var status string
+	if path := strings.TrimPrefix(pageURL, BaseURL()); path != pageURL && (path == "" || strings.HasPrefix(path, "/")) {
+		u, err := url.Parse(path)
+		if err != nil {
+			return false, fmt.Errorf("parse %s: %s", pageURL, err)
+		}
+		resp, err := send(ctx, http.MethodGet, u.Path, u.Query(), nil)
+		if err != nil {
+			return false, err
+		}
+		code, status = resp.code, resp.status
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+		if err != nil {
+			return false, fmt.Errorf("create GET %s request: %s", pageURL, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false, fmt.Errorf("GET %s: %s", pageURL, err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("warning: could not close response body: %s", err)
+		}
+		code, status = resp.StatusCode, resp.Status
+	}
+
+	switch {
+	case code == http.StatusOK:
+		return true, nil
+	case code == http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("GET %s: %s", pageURL, status)
+	}
+}
+
+// CloseTopic closes the topic at topicURL, so no more replies can be posted to it.
+func CloseTopic(ctx context.Context, topicURL string) error {
+	topicID, err := parseTopicID(topicURL)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"status":  "closed",
+		"enabled": "true",
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal payload: %s", err)
+	}
+
+	path := fmt.Sprintf("/t/%d/status.json", topicID)
+	resp, err := send(ctx, http.MethodPut, path, nil, payload)
+	if err != nil {
+		return err
+	}
+	if resp.code != http.StatusOK {
+		return fmt.Errorf("api error: PUT %s: %s %s", path, resp.status, resp.body)
+	}
+	return nil
+}
+
+// DeleteTopic deletes the topic at topicURL along with its replies.
+func DeleteTopic(ctx context.Context, topicURL string) error {
+	topicID, err := parseTopicID(topicURL)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/t/%d.json", topicID)
+	resp, err := send(ctx, http.MethodDelete, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.code != http.StatusOK {
+		return fmt.Errorf("api error: DELETE %s: %s %s", path, resp.status, resp.body)
+	}
+	return nil
+}
+
+func topicURL(topicID int64) string {
+	return fmt.Sprintf("%s/t/%d", BaseURL(), topicID)
+}
+
+func parseTopicID(topicURL string) (int64, error) {
+	fragments := strings.Split(strings.TrimSuffix(topicURL, "/"), "/")
+	topicID, err := strconv.ParseInt(fragments[len(fragments)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse topic id from %s: %s", topicURL, err)
+	}
+	return topicID, nil
+}
+
+// newRequest creates a request to the discourse API, authenticated by the
+// Api-Key and Api-Username headers, so that credentials never end up in URLs.
+func newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, BaseURL()+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("create %s %s request: %s", method, path, err)
+	}
+	req.URL.RawQuery = query.Encode()
+	req.Header.Set("Api-Key", discourseAPIKey)
+	req.Header.Set("Api-Username", discourseAPIUser)
+	return req, nil
+}
+
+type searchResult struct {
+	Posts []struct {
+		ID         int64 `json:"id"`
+		TopicID    int64 `json:"topic_id"`
+		PostNumber int   `json:"post_number"`
+	} `json:"posts"`
+}
+
+type post struct {
+	ID      int64  `json:"id"`
+	TopicID int64  `json:"topic_id"`
+	Raw     string `json:"raw"`
+}
+
+// FindTopic searches Discourse for a topic created from the GitHub issue at
+// originURL by a previous run, and returns its URL or an empty string.
+func FindTopic(ctx context.Context, originURL string) (string, error) {
+	query := url.Values{"q": []string{originURL}}
+
+	var result searchResult
+	if err := get(ctx, "/search.json", query, &result); err != nil {
+		return "", fmt.Errorf("search for %s: %s", originURL, err)
+	}
+
+	for _, p := range result.Posts {
+		if p.PostNumber != 1 {
+			continue
+		}
+
+		var first post
+		if err := get(ctx, fmt.Sprintf("/posts/%d.json", p.ID), nil, &first); err != nil {
+			return "", fmt.Errorf("fetch post %d: %s", p.ID, err)
+		}
+
+		if m, ok := marker.Find(first.Raw); ok && m.IssueURL == originURL {
+			return topicURL(first.TopicID), nil
+		}
+	}
+
+	return "", nil
+}
+
+func get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	body, err := getBody(ctx, path, query)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("could not unmarshal response body %s; reason: %s", body, err)
 	}
-	discourseURL := fmt.Sprintf("https://discuss.bitrise.io/t/%d", topicID)
+	return nil
+}
 
-	return discourseURL, nil
+func getBody(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	resp, err := send(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.code != http.StatusOK {
+		return nil, fmt.Errorf("api error: GET %s: %s %s", path, resp.status, resp.body)
+	}
+	return resp.body, nil
 }
@@ -0,0 +1,136 @@
+package discourse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/lszucs/github-to-discourse/internal/marker"
+)
+
+// serve points the client to a test server handling the requests with h,
+// without request budgets, until the test ends.
+func serve(t *testing.T, h http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	oldURL, oldRequests, oldTopics := baseURL, requestsPerMinute, topicsPerMinute
+	baseURL, requestsPerMinute, topicsPerMinute = srv.URL, 0, 0
+	t.Cleanup(func() {
+		srv.Close()
+		baseURL, requestsPerMinute, topicsPerMinute = oldURL, oldRequests, oldTopics
+	})
+	return srv
+}
+
+func TestPostedRepliesPages(t *testing.T) {
+	const issueURL = "https://github.com/bitrise-io/bitrise-init/issues/12"
+	tests := []struct {
+		name  string
+		pages []string
+		want  map[string]bool
+	}{
+		{
+			name:  "no replies",
+			pages: []string{"the topic"},
+			want:  map[string]bool{},
+		},
+		{
+			name: "replies past the first page",
+			pages: []string{
+				marker.Append("the topic", marker.Marker{IssueURL: issueURL}) + "\n" +
+					marker.Append("reply", marker.Marker{IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-1"}),
+				marker.Append("reply", marker.Marker{IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-101"}),
+			},
+			want: map[string]bool{
+				issueURL + "#issuecomment-1":   true,
+				issueURL + "#issuecomment-101": true,
+			},
+		},
+		{
+			name: "page parameter ignored",
+			pages: []string{
+				marker.Append("reply", marker.Marker{IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-1"}),
+				marker.Append("reply", marker.Marker{IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-1"}),
+				marker.Append("reply", marker.Marker{IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-1"}),
+			},
+			want: map[string]bool{issueURL + "#issuecomment-1": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested := 0
+			srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/raw/34" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				requested++
+				page, err := strconv.Atoi(r.URL.Query().Get("page"))
+				if err != nil || page < 1 {
+					t.Errorf("invalid page %q", r.URL.Query().Get("page"))
+					return
+				}
+				if page <= len(tt.pages) {
+					fmt.Fprint(w, tt.pages[page-1])
+				}
+			})
+
+			got, err := PostedReplies(context.Background(), srv.URL+"/t/34")
+			if err != nil {
+				t.Fatalf("PostedReplies() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PostedReplies() = %v, want %v", got, tt.want)
+			}
+			if requested > len(tt.pages)+1 {
+				t.Errorf("requested %d pages of %d", requested, len(tt.pages))
+			}
+		})
+	}
+}
+
+func TestPageExists(t *testing.T) {
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tag/xcode-test":
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/support" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer other.Close()
+
+	tests := []struct {
+		name    string
+		pageURL string
+		want    bool
+		wantErr bool
+	}{
+		{name: "existing", pageURL: srv.URL + "/tag/xcode-test", want: true},
+		{name: "missing", pageURL: srv.URL + "/tag/certificate-and-profile-installer", want: false},
+		{name: "server error", pageURL: srv.URL + "/broken", wantErr: true},
+		{name: "outside the instance", pageURL: other.URL + "/support", want: true},
+		{name: "missing outside the instance", pageURL: other.URL + "/tag/xcode-test", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PageExists(context.Background(), tt.pageURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PageExists(%s) error = %v, wantErr %v", tt.pageURL, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PageExists(%s) = %v, want %v", tt.pageURL, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package discourse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+
+	"github.com/lszucs/github-to-discourse/internal/retry"
+)
+
+const (
+	defaultMaxRetries        = 5
+	defaultRequestsPerMinute = 60
+	defaultTopicsPerMinute   = 4
+)
+
+var (
+	maxRetries        int
+	requestsPerMinute int
+	topicsPerMinute   int
+
+	requestBudget = limiter{perMinute: &requestsPerMinute}
+	topicBudget   = limiter{perMinute: &topicsPerMinute}
+)
+
+func init() {
+	flag.IntVar(&maxRetries, "discourse-max-retries", defaultMaxRetries, "--discourse-max-retries=<int> (number of times a rate limited or failed discourse request is retried)")
+	flag.IntVar(&requestsPerMinute, "discourse-requests-per-minute", defaultRequestsPerMinute, "--discourse-requests-per-minute=<int> (budget of all discourse API requests, 0 for unlimited)")
+	flag.IntVar(&topicsPerMinute, "discourse-topics-per-minute", defaultTopicsPerMinute, "--discourse-topics-per-minute=<int> (budget of discourse topic creation, 0 for unlimited)")
+}
+
+// limiter spaces out calls to wait evenly to stay within a per minute budget.
+type limiter struct {
+	perMinute *int
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	if *l.perMinute <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(time.Minute / time.Duration(*l.perMinute))
+	l.mu.Unlock()
+
+	return retry.Sleep(ctx, time.Until(slot))
+}
+
+type response struct {
+	status string
+	code   int
+	body   []byte
+}
+
+// send sends a request within the request budget, retrying it as long as
+// discourse rejects it with 429 Too Many Requests, which is always safe as
+// rejected requests have no effect.
+func send(ctx context.Context, method, path string, query url.Values, payload []byte) (response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := requestBudget.wait(ctx); err != nil {
+			return response{}, err
+		}
+
+		req, err := newRequest(ctx, method, path, query, bytes.NewReader(payload))
+		if err != nil {
+			return response{}, err
+		}
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return response{}, fmt.Errorf("%s %s: %s", method, path, err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("warning: could not close response body: %s", cerr)
+		}
+		if err != nil {
+			return response{}, fmt.Errorf("could not read response body: %s", err)
+		}
+
+		r := response{status: resp.Status, code: resp.StatusCode, body: body}
+		if r.code != http.StatusTooManyRequests || attempt >= maxRetries {
+			return r, nil
+		}
+
+		wait := retryAfter(resp.Header.Get("Retry-After"), body)
+		if wait <= 0 {
+			wait = retry.Backoff(attempt)
+		}
+		log.Warnf("%s %s: %s, retrying in %s", method, path, r.status, wait.Round(time.Second))
+		if err := retry.Sleep(ctx, wait); err != nil {
+			return response{}, err
+		}
+	}
+}
+
+// retryAfter returns the wait discourse asks for in the Retry-After header
+// or the wait_seconds of the error body, or 0 if it does not tell.
+func retryAfter(header string, body []byte) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds)*time.Second + retry.Jitter()
+	}
+
+	var data struct {
+		Extras struct {
+			WaitSeconds float64 `json:"wait_seconds"`
+		} `json:"extras"`
+	}
+	if err := json.Unmarshal(body, &data); err == nil && data.Extras.WaitSeconds > 0 {
+		return time.Duration(data.Extras.WaitSeconds*float64(time.Second)) + retry.Jitter()
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package discourse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		min    time.Duration
+	}{
+		{name: "retry-after header", header: "3", body: `{}`, min: 3 * time.Second},
+		{name: "wait_seconds", body: `{"errors": ["slow down"], "extras": {"wait_seconds": 1.5}}`, min: 1500 * time.Millisecond},
+		{name: "header before body", header: "2", body: `{"extras": {"wait_seconds": 10}}`, min: 2 * time.Second},
+		{name: "not told", body: `{"errors": ["slow down"]}`},
+		{name: "not json", header: "soon", body: `slow down`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryAfter(tt.header, []byte(tt.body))
+			if tt.min == 0 {
+				if got != 0 {
+					t.Errorf("retryAfter() = %s, want 0", got)
+				}
+				return
+			}
+			// a jitter of up to a second is added
+			if got < tt.min || got >= tt.min+time.Second {
+				t.Errorf("retryAfter() = %s, want %s plus jitter", got, tt.min)
+			}
+		})
+	}
+}
+
+func TestSendRetriesTooManyRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		rejected func(w http.ResponseWriter)
+	}{
+		{
+			name: "retry-after header",
+			rejected: func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+		},
+		{
+			name: "wait_seconds",
+			rejected: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"extras": {"wait_seconds": 0.01}}`)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			serve(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				n := requests
+				mu.Unlock()
+				if n == 1 {
+					tt.rejected(w)
+					return
+				}
+				fmt.Fprint(w, `{}`)
+			})
+
+			resp, err := send(context.Background(), http.MethodGet, "/latest.json", nil, nil)
+			if err != nil {
+				t.Fatalf("send() error = %v", err)
+			}
+			if resp.code != http.StatusOK {
+				t.Errorf("send() = %s, want 200", resp.status)
+			}
+			if requests != 2 {
+				t.Errorf("sent %d requests, want 2", requests)
+			}
+		})
+	}
+}
+
+func TestCreatePost(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		exists     bool
+		want       int64
+		wantErr    bool
+		wantChecks int
+	}{
+		{name: "created", status: http.StatusOK, body: `{"id": 7, "topic_id": 34}`, want: 34},
+		{name: "created despite server error", status: http.StatusBadGateway, exists: true, want: 12, wantChecks: 1},
+		{name: "rejected", status: http.StatusUnprocessableEntity, body: `{"errors": ["Title has already been used"]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			posts := 0
+			serve(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/posts.json" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+					return
+				}
+				mu.Lock()
+				posts++
+				mu.Unlock()
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+
+			checks := 0
+			got, err := createPost(context.Background(), map[string]interface{}{"topic_id": 12, "raw": "reply"}, func() (int64, bool, error) {
+				checks++
+				return 12, tt.exists, nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createPost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("createPost() = %d, want %d", got, tt.want)
+			}
+			if posts != 1 {
+				t.Errorf("sent %d posts, want 1", posts)
+			}
+			if checks != tt.wantChecks {
+				t.Errorf("checked whether the post exists %d times, want %d", checks, tt.wantChecks)
+			}
+		})
+	}
+}
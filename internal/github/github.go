@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,21 +15,36 @@ import (
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
+
+	"github.com/lszucs/github-to-discourse/internal/marker"
 )
 
+const defaultPageSize = 100
+
 var (
-	client *github.Client
-	ctx    context.Context
-	tc     *http.Client
+	pageSize    int
+	botAccounts string
+	client      *github.Client
+	rateLimit   *rateLimitTransport
+	tc          *http.Client
 )
 
 func init() {
-	ctx = context.Background()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: os.Getenv("GITHUB_ACCESS_TOKEN")},
 	)
-	tc = oauth2.NewClient(ctx, ts)
+	tc = oauth2.NewClient(context.Background(), ts)
+	rateLimit = newRateLimitTransport(tc.Transport)
+	tc.Transport = rateLimit
 	client = github.NewClient(tc)
+
+	flag.StringVar(&botAccounts, "bot-accounts", "", "--bot-accounts=ci-bot,renovate (comma separated GitHub logins whose activity does not count against staleness)")
+	flag.IntVar(&pageSize, "github-page-size", defaultPageSize, "--github-page-size=<int> (number of issues or repos to request per page from GitHub, max 100)")
+}
+
+// RateLimit returns the core GitHub API calls left and when the limit resets, or -1 if unknown.
+func RateLimit() (int, time.Time) {
+	return rateLimit.Remaining()
 }
 
 func GetHTMLURLs(issues []*github.Issue) []string {
@@ -39,41 +55,333 @@ func GetHTMLURLs(issues []*github.Issue) []string {
 	return urls
 }
 
-func GetOpenIssues(repoURLs []string) []*github.Issue {
+// RepoCount reports how many open issues were fetched from a repository
+// compared to the open issue count GitHub reports for it, or why the issues
+// could not be listed.
+type RepoCount struct {
+	Repo     string
+	Fetched  int
+	Expected int
+	Err      error
+}
+
+// GetOpenIssues fetches the open issues of the repos, reporting the repos
+// whose issues could not be listed in their count. It returns the issues
+// fetched so far along with ctx's error if ctx is cancelled.
+func GetOpenIssues(ctx context.Context, repos []Repo) ([]*github.Issue, []RepoCount, error) {
+	var all []*github.Issue
+	var counts []RepoCount
+	for _, r := range repos {
+		if err := ctx.Err(); err != nil {
+			return all, counts, err
+		}
+
+		issues, err := listIssues(ctx, r.Owner, r.Name, "open")
+		if err != nil {
+			counts = append(counts, RepoCount{Repo: r.String(), Expected: -1, Err: err})
+			continue
+		}
+
+		count := RepoCount{
+			Repo:     r.String(),
+			Fetched:  len(issues),
+			Expected: -1,
+		}
+		repo, _, err := client.Repositories.Get(ctx, r.Owner, r.Name)
+		if err != nil {
+			log.Warnf("fetch repo %s: %s", r, err)
+		} else {
+			count.Expected = repo.GetOpenIssuesCount()
+		}
+		counts = append(counts, count)
+
+		all = append(all, issues...)
+	}
+	return all, counts, nil
+}
+
+// GetAllIssues fetches both the open and the closed issues of the repos.
+func GetAllIssues(ctx context.Context, repos []Repo) ([]*github.Issue, error) {
+	var all []*github.Issue
+	for _, r := range repos {
+		issues, err := listIssues(ctx, r.Owner, r.Name, "all")
+		if err != nil {
+			return nil, fmt.Errorf("fetch issues from %s: %s", r, err)
+		}
+		all = append(all, issues...)
+	}
+	return all, nil
+}
+
+func listIssues(ctx context.Context, owner, name, state string) ([]*github.Issue, error) {
 	var all []*github.Issue
 	opts := github.IssueListByRepoOptions{
-		State: "open",
+		State: state,
+		ListOptions: github.ListOptions{
+			PerPage: pageSize,
+		},
 	}
-	for _, url := range repoURLs {
-		fragments := strings.Split(string(url), "/")
-		owner := fragments[len(fragments)-2]
-		name := strings.TrimSuffix(fragments[len(fragments)-1], ".git")
-		
+	for {
 		issues, resp, err := client.Issues.ListByRepo(ctx, owner, name, &opts)
 		if err != nil {
-			log.Warnf("fetch issues from %s: %s", url, err)
-			continue
+			return nil, fmt.Errorf("list page %d: %s", opts.Page, err)
 		}
 
 		if resp.Response.StatusCode != 200 {
-			log.Warnf("fetch issues from %s: %s", url, resp.Response.Status)
-			continue
+			return nil, fmt.Errorf("list page %d: %s", opts.Page, resp.Response.Status)
 		}
 
 		all = append(all, issues...)
 
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListOrgRepos lists the repositories of the org of the given type: all,
+// public, private, forks, sources or member.
+func ListOrgRepos(ctx context.Context, org, repoType string) ([]*github.Repository, error) {
+	var all []*github.Repository
+	opts := github.RepositoryListByOrgOptions{
+		Type: repoType,
+		ListOptions: github.ListOptions{
+			PerPage: pageSize,
+		},
+	}
+	for {
+		repos, resp, err := client.Repositories.ListByOrg(ctx, org, &opts)
+		if err != nil {
+			return nil, fmt.Errorf("list page %d: %s", opts.Page, err)
+		}
+
+		if resp.Response.StatusCode != 200 {
+			return nil, fmt.Errorf("list page %d: %s", opts.Page, resp.Response.Status)
+		}
+
+		all = append(all, repos...)
+
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// searchResultLimit is the number of results the GitHub search API returns at most for a query.
+const searchResultLimit = 1000
+
+// SearchRepos returns the repositories matching the GitHub search query. It
+// fails if the query matches more repositories than the search API returns.
+func SearchRepos(ctx context.Context, query string) ([]github.Repository, error) {
+	var all []github.Repository
+	opts := github.SearchOptions{
+		ListOptions: github.ListOptions{
+			PerPage: pageSize,
+		},
+	}
+	for {
+		result, resp, err := client.Search.Repositories(ctx, query, &opts)
+		if err != nil {
+			return nil, fmt.Errorf("search page %d: %s", opts.Page, err)
+		}
+
+		if resp.Response.StatusCode != 200 {
+			return nil, fmt.Errorf("search page %d: %s", opts.Page, resp.Response.Status)
+		}
+		if result.GetTotal() > searchResultLimit {
+			return nil, fmt.Errorf("query matches %d repos, more than the %d the search API returns, narrow it down", result.GetTotal(), searchResultLimit)
+		}
+		if result.GetIncompleteResults() {
+			return nil, fmt.Errorf("search page %d: incomplete results, the search timed out", opts.Page)
+		}
+
+		all = append(all, result.Repositories...)
+
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// contentEvents are the timeline events, besides comments, which count as
+// activity on an issue. Labeling, assignments, references and the like do not.
+var contentEvents = map[string]bool{
+	"reopened": true,
+	"renamed":  true,
+}
+
+// IsBot tells whether the user is a bot account, either by its GitHub
+// account type or by being listed in --bot-accounts.
+func IsBot(u *github.User) bool {
+	if u.GetType() == "Bot" || strings.HasSuffix(u.GetLogin(), "[bot]") {
+		return true
+	}
+	for _, b := range strings.Split(botAccounts, ",") {
+		if b != "" && strings.EqualFold(strings.TrimSpace(b), u.GetLogin()) {
+			return true
+		}
+	}
+	return false
+}
+
+// LastHumanActivity returns the time the issue was last opened, commented on,
+// reopened or renamed by a user other than a bot. It is the zero time if there was none.
+func LastHumanActivity(ctx context.Context, i *github.Issue) (time.Time, error) {
+	var last time.Time
+	if !IsBot(i.GetUser()) {
+		last = i.GetCreatedAt()
+	}
+
+	if i.GetComments() > 0 {
+		comments, err := GetComments(ctx, i)
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, c := range comments {
+			if IsBot(c.GetUser()) || IsMigrationComment(c) {
+				continue
+			}
+			if c.GetCreatedAt().After(last) {
+				last = c.GetCreatedAt()
+			}
+		}
+	}
+
+	events, err := getTimeline(ctx, i)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, e := range events {
+		if !contentEvents[e.GetEvent()] || IsBot(e.GetActor()) {
+			continue
+		}
+		if e.GetCreatedAt().After(last) {
+			last = e.GetCreatedAt()
+		}
+	}
+	return last, nil
+}
+
+func getTimeline(ctx context.Context, i *github.Issue) ([]*github.Timeline, error) {
+	owner, name := IssueRepo(i)
+	var all []*github.Timeline
+	opts := github.ListOptions{
+		PerPage: pageSize,
+	}
+	for {
+		events, resp, err := client.Issues.ListIssueTimeline(ctx, owner, name, i.GetNumber(), &opts)
+		if err != nil {
+			return nil, fmt.Errorf("list timeline page %d of %s: %s", opts.Page, i.GetHTMLURL(), err)
+		}
+
+		all = append(all, events...)
+
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
 	}
-	return all
 }
 
-func IsStale(i *github.Issue) bool {
-	threeMonthsAgo := time.Now().AddDate(0, -3, 0)
-	return i.GetUpdatedAt().Before(threeMonthsAgo)
+// IssueRepo returns the owner and name of the repository the issue belongs to.
+func IssueRepo(i *github.Issue) (string, string) {
+	r, err := ParseRepo(i.GetHTMLURL())
+	if err != nil {
+		return "", ""
+	}
+	return r.Owner, r.Name
 }
 
-func PostComment(i *github.Issue, comment string) error {
+func GetIssue(ctx context.Context, owner, name string, number int) (*github.Issue, error) {
+	i, _, err := client.Issues.Get(ctx, owner, name, number)
+	if err != nil {
+		return nil, fmt.Errorf("fetch issue %d of %s/%s: %s", number, owner, name, err)
+	}
+	return i, nil
+}
+
+// GetIssueByURL fetches the issue at the given HTML URL, e.g. https://github.com/owner/repo/issues/1.
+func GetIssueByURL(ctx context.Context, htmlURL string) (*github.Issue, error) {
+	r, number, err := ParseIssueURL(htmlURL)
+	if err != nil {
+		return nil, err
+	}
+	return GetIssue(ctx, r.Owner, r.Name, number)
+}
+
+// GetComments returns all comments of the issue in chronological order.
+func GetComments(ctx context.Context, i *github.Issue) ([]*github.IssueComment, error) {
+	owner, name := IssueRepo(i)
+	var all []*github.IssueComment
+	opts := github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{
+			PerPage: pageSize,
+		},
+	}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, name, i.GetNumber(), &opts)
+		if err != nil {
+			return nil, fmt.Errorf("list comments page %d of %s: %s", opts.Page, i.GetHTMLURL(), err)
+		}
+
+		all = append(all, comments...)
+
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// IsMigrationComment tells whether the comment was written by the migration.
+func IsMigrationComment(c *github.IssueComment) bool {
+	_, ok := marker.Find(c.GetBody())
+	return ok
+}
+
+// FindMigrationComment returns the comment on the issue carrying a migration
+// marker, or nil if the issue has not been commented on by a previous run.
+func FindMigrationComment(ctx context.Context, i *github.Issue) (*github.IssueComment, marker.Marker, error) {
+	comments, markers, err := FindMigrationComments(ctx, i)
+	if err != nil || len(comments) == 0 {
+		return nil, marker.Marker{}, err
+	}
+	return comments[0], markers[0], nil
+}
+
+// FindMigrationComments returns all comments on the issue carrying a migration marker of the issue.
+func FindMigrationComments(ctx context.Context, i *github.Issue) ([]*github.IssueComment, []marker.Marker, error) {
+	// spare listing the comments of issues without any
+	if i.GetComments() == 0 {
+		return nil, nil, nil
+	}
+	comments, err := GetComments(ctx, i)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var found []*github.IssueComment
+	var markers []marker.Marker
+	for _, c := range comments {
+		if m, ok := marker.Find(c.GetBody()); ok && m.IssueURL == i.GetHTMLURL() {
+			found = append(found, c)
+			markers = append(markers, m)
+		}
+	}
+	return found, markers, nil
+}
+
+// PostComment comments on the issue, appending a migration marker which
+// references topicURL, if the issue was posted to Discourse.
+func PostComment(ctx context.Context, i *github.Issue, comment, topicURL string) error {
 	payload := map[string]interface{}{
-		"body": comment,
+		"body": marker.Append(comment, marker.Marker{
+			IssueURL: i.GetHTMLURL(),
+			TopicURL: topicURL,
+		}),
 	}
 
 	data, err := json.Marshal(payload)
@@ -81,7 +389,7 @@ func PostComment(i *github.Issue, comment string) error {
 		return fmt.Errorf("marshal %s: %s", payload, err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, i.GetCommentsURL(), bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.GetCommentsURL(), bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("create POST %s request with request body %s: %s", i.GetCommentsURL(), string(data), err)
 	}
@@ -107,7 +415,7 @@ func PostComment(i *github.Issue, comment string) error {
 	return nil
 }
 
-func Close(i *github.Issue) error {
+func Close(ctx context.Context, i *github.Issue) error {
 	payload := map[string]interface{}{
 		"state": "closed",
 	}
@@ -117,7 +425,7 @@ func Close(i *github.Issue) error {
 		return fmt.Errorf("could not marshal %s: %s", payload, err)
 	}
 
-	request, err := http.NewRequest("PATCH", i.GetURL(), bytes.NewBuffer(data))
+	request, err := http.NewRequestWithContext(ctx, "PATCH", i.GetURL(), bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("could not create request: %s", err)
 	}
@@ -146,9 +454,9 @@ func Close(i *github.Issue) error {
 	return nil
 }
 
-func Lock(i *github.Issue) error {
+func Lock(ctx context.Context, i *github.Issue) error {
 	url := fmt.Sprintf("%s/lock", i.GetURL())
-	request, err := http.NewRequest("PUT", url, bytes.NewBuffer([]byte{}))
+	request, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer([]byte{}))
 	request.Header.Add("Content-Length", "0")
 	if err != nil {
 		return fmt.Errorf("could not create request: %s", err)
@@ -177,3 +485,28 @@ func Lock(i *github.Issue) error {
 
 	return nil
 }
+
+func Reopen(ctx context.Context, i *github.Issue) error {
+	owner, name := IssueRepo(i)
+	state := "open"
+	if _, _, err := client.Issues.Edit(ctx, owner, name, i.GetNumber(), &github.IssueRequest{State: &state}); err != nil {
+		return fmt.Errorf("reopen %s: %s", i.GetHTMLURL(), err)
+	}
+	return nil
+}
+
+func Unlock(ctx context.Context, i *github.Issue) error {
+	owner, name := IssueRepo(i)
+	if _, err := client.Issues.Unlock(ctx, owner, name, i.GetNumber()); err != nil {
+		return fmt.Errorf("unlock %s: %s", i.GetHTMLURL(), err)
+	}
+	return nil
+}
+
+func DeleteComment(ctx context.Context, i *github.Issue, c *github.IssueComment) error {
+	owner, name := IssueRepo(i)
+	if _, err := client.Issues.DeleteComment(ctx, owner, name, c.GetID()); err != nil {
+		return fmt.Errorf("delete comment %s: %s", c.GetHTMLURL(), err)
+	}
+	return nil
+}
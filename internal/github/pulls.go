@@ -0,0 +1,98 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// DefaultBranch returns the name of the default branch of the repo.
+func DefaultBranch(ctx context.Context, r Repo) (string, error) {
+	repo, _, err := client.Repositories.Get(ctx, r.Owner, r.Name)
+	if err != nil {
+		return "", fmt.Errorf("fetch repo %s: %s", r, err)
+	}
+	return repo.GetDefaultBranch(), nil
+}
+
+// GetFile returns the content and blob SHA of the file at path on the ref of the repo.
+func GetFile(ctx context.Context, r Repo, path, ref string) (string, string, error) {
+	file, _, _, err := client.Repositories.GetContents(ctx, r.Owner, r.Name, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		return "", "", fmt.Errorf("fetch %s of %s@%s: %s", path, r, ref, err)
+	}
+	if file == nil {
+		return "", "", fmt.Errorf("%s of %s@%s is a directory", path, r, ref)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return "", "", fmt.Errorf("decode %s of %s@%s: %s", path, r, ref, err)
+	}
+	return content, file.GetSHA(), nil
+}
+
+// CreateBranch creates the branch from the head of the base branch. It
+// returns false if the branch already exists.
+func CreateBranch(ctx context.Context, r Repo, branch, base string) (bool, error) {
+	ref, _, err := client.Git.GetRef(ctx, r.Owner, r.Name, "heads/"+base)
+	if err != nil {
+		return false, fmt.Errorf("fetch branch %s of %s: %s", base, r, err)
+	}
+
+	_, _, err = client.Git.CreateRef(ctx, r.Owner, r.Name, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: ref.Object.SHA},
+	})
+	if e, ok := err.(*github.ErrorResponse); ok && e.Response.StatusCode == http.StatusUnprocessableEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("create branch %s of %s: %s", branch, r, err)
+	}
+	return true, nil
+}
+
+// UpdateFile commits the new content of the file at path, whose current blob SHA is sha, to the branch.
+func UpdateFile(ctx context.Context, r Repo, path, branch, message, content, sha string) error {
+	_, _, err := client.Repositories.UpdateFile(ctx, r.Owner, r.Name, path, &github.RepositoryContentFileOptions{
+		Message: github.String(message),
+		Content: []byte(content),
+		SHA:     github.String(sha),
+		Branch:  github.String(branch),
+	})
+	if err != nil {
+		return fmt.Errorf("update %s of %s@%s: %s", path, r, branch, err)
+	}
+	return nil
+}
+
+// FindOpenPR returns the open pull request of the branch of the repo, or nil if there is none.
+func FindOpenPR(ctx context.Context, r Repo, branch string) (*github.PullRequest, error) {
+	prs, _, err := client.PullRequests.List(ctx, r.Owner, r.Name, &github.PullRequestListOptions{
+		State: "open",
+		Head:  r.Owner + ":" + branch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list pull requests of %s: %s", r, err)
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0], nil
+}
+
+// OpenPR opens a pull request of the head branch against the base branch of the repo.
+func OpenPR(ctx context.Context, r Repo, title, body, head, base string) (*github.PullRequest, error) {
+	pr, _, err := client.PullRequests.Create(ctx, r.Owner, r.Name, &github.NewPullRequest{
+		Title: github.String(title),
+		Body:  github.String(body),
+		Head:  github.String(head),
+		Base:  github.String(base),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open pull request of %s: %s", r, err)
+	}
+	return pr, nil
+}
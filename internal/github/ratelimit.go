@@ -0,0 +1,235 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+
+	"github.com/lszucs/github-to-discourse/internal/retry"
+)
+
+const (
+	defaultMaxRetries = 5
+)
+
+var maxRetries int
+
+func init() {
+	flag.IntVar(&maxRetries, "github-max-retries", defaultMaxRetries, "--github-max-retries=<int> (number of times a rate limited or failed GitHub request is retried)")
+}
+
+// rateLimitTransport throttles GitHub requests according to the rate limit
+// headers of the responses, and retries requests rejected by the primary or
+// secondary rate limits. Server errors and network failures are retried for
+// idempotent requests only, as the request may have been applied.
+//
+// GitHub limits some APIs, like search, separately from the core API, so
+// the limits are tracked per the X-RateLimit-Resource of the responses.
+type rateLimitTransport struct {
+	base http.RoundTripper
+
+	mu     sync.Mutex
+	limits map[string]resourceLimit
+}
+
+type resourceLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+const (
+	coreResource   = "core"
+	searchResource = "search"
+)
+
+func newRateLimitTransport(base http.RoundTripper) *rateLimitTransport {
+	return &rateLimitTransport{
+		base:   base,
+		limits: map[string]resourceLimit{},
+	}
+}
+
+// Remaining returns the core GitHub API calls left in the current rate limit
+// window and when the window resets, or -1 if no response was seen yet.
+func (t *rateLimitTransport) Remaining() (int, time.Time) {
+	return t.limit(coreResource)
+}
+
+// limit returns the calls left of the resource and when its window resets,
+// or -1 if no response of the resource was seen yet.
+func (t *rateLimitTransport) limit(resource string) (int, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.limits[resource]
+	if !ok {
+		return -1, time.Time{}
+	}
+	return l.remaining, l.reset
+}
+
+// resource returns the rate limit resource the request counts against.
+func resource(req *http.Request) string {
+	if strings.HasPrefix(req.URL.Path, "/search/") {
+		return searchResource
+	}
+	return coreResource
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.waitForReset(req.Context(), resource(req)); err != nil {
+			return nil, err
+		}
+
+		r := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewind request body: %s", err)
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		if err != nil {
+			if !isIdempotent(req.Method) || attempt >= maxRetries {
+				return nil, err
+			}
+			wait := retry.Backoff(attempt)
+			log.Warnf("%s %s failed, retrying in %s: %s", req.Method, req.URL.Path, wait, err)
+			if err := retry.Sleep(req.Context(), wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		t.update(resp)
+
+		wait, limited, err := t.retryAfter(resp)
+		if err != nil {
+			return nil, err
+		}
+		if !limited && !(resp.StatusCode >= 500 && isIdempotent(req.Method)) {
+			return t.deliver(req.Context(), resp)
+		}
+		if attempt >= maxRetries {
+			return resp, nil
+		}
+
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("warning: close response body: %s", err)
+		}
+		if wait <= 0 {
+			wait = retry.Backoff(attempt)
+		}
+		log.Warnf("%s %s: %s, retrying in %s", req.Method, req.URL.Path, resp.Status, wait.Round(time.Second))
+		if err := retry.Sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// deliver returns the response once the rate limit window it exhausted, if
+// any, resets. go-github fails every call without sending it while the last
+// response it saw had no calls left, so the wait can not be left to the next
+// request.
+func (t *rateLimitTransport) deliver(ctx context.Context, resp *http.Response) (*http.Response, error) {
+	if err := t.waitForReset(ctx, responseResource(resp)); err != nil {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Warnf("warning: close response body: %s", cerr)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// waitForReset sleeps until the rate limit window of the resource resets if
+// no calls are left in the current one.
+func (t *rateLimitTransport) waitForReset(ctx context.Context, resource string) error {
+	remaining, reset := t.limit(resource)
+	if remaining != 0 {
+		return nil
+	}
+	if wait := time.Until(reset); wait > 0 {
+		log.Warnf("GitHub %s rate limit exhausted, waiting %s until it resets", resource, wait.Round(time.Second))
+		return retry.Sleep(ctx, wait+time.Second)
+	}
+	return nil
+}
+
+func (t *rateLimitTransport) update(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits[responseResource(resp)] = resourceLimit{remaining: remaining, reset: time.Unix(reset, 0)}
+}
+
+// responseResource returns the rate limit resource the response counted against.
+func responseResource(resp *http.Response) string {
+	if r := resp.Header.Get("X-RateLimit-Resource"); r != "" {
+		return r
+	}
+	return coreResource
+}
+
+// retryAfter tells whether the response is a rate limit rejection and how
+// long to wait before retrying, 0 meaning exponential backoff.
+func (t *rateLimitTransport) retryAfter(resp *http.Response) (time.Duration, bool, error) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false, nil
+	}
+
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil {
+			return time.Duration(seconds)*time.Second + retry.Jitter(), true, nil
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		_, reset := t.limit(responseResource(resp))
+		return time.Until(reset) + time.Second + retry.Jitter(), true, nil
+	}
+
+	// secondary rate limits are only told apart from other 403s by the message
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, false, fmt.Errorf("read response body: %s", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Warnf("warning: close response body: %s", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	msg := strings.ToLower(string(body))
+	if strings.Contains(msg, "secondary rate limit") || strings.Contains(msg, "abuse") {
+		return 0, true, nil
+	}
+	return 0, resp.StatusCode == http.StatusTooManyRequests, nil
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestRateLimitTransportWaitsForReset(t *testing.T) {
+	var mu sync.Mutex
+	var calls []time.Time
+	reset := time.Now().Add(time.Second).Truncate(time.Second)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, time.Now())
+		first := len(calls) == 1
+		mu.Unlock()
+
+		w.Header().Set("X-RateLimit-Resource", "core")
+		w.Header().Set("X-RateLimit-Limit", "60")
+		if first {
+			// the call exhausting the window succeeds
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		} else {
+			w.Header().Set("X-RateLimit-Remaining", "59")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Add(time.Hour).Unix(), 10))
+		}
+		fmt.Fprint(w, `[{"number": 1}]`)
+	}))
+	defer srv.Close()
+
+	c := github.NewClient(&http.Client{Transport: newRateLimitTransport(http.DefaultTransport)})
+	baseURL, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = baseURL
+
+	ctx := context.Background()
+	for n := 0; n < 2; n++ {
+		issues, _, err := c.Issues.ListByRepo(ctx, "bitrise-io", "bitrise-init", nil)
+		if err != nil {
+			t.Fatalf("ListByRepo() call %d error = %v", n+1, err)
+		}
+		if len(issues) != 1 {
+			t.Fatalf("ListByRepo() call %d = %d issues, want 1", n+1, len(issues))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 {
+		t.Fatalf("server got %d calls, want 2", len(calls))
+	}
+	if !calls[1].After(reset) {
+		t.Errorf("second call at %s, want after the reset at %s", calls[1], reset)
+	}
+}
+
+func TestRateLimitTransportTracksResources(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/search/issues" {
+			w.Header().Set("X-RateLimit-Resource", "search")
+			w.Header().Set("X-RateLimit-Remaining", "29")
+		} else {
+			w.Header().Set("X-RateLimit-Resource", "core")
+			w.Header().Set("X-RateLimit-Remaining", "4999")
+		}
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		fmt.Fprint(w, `{}`)
+	}))
+	defer srv.Close()
+
+	tr := newRateLimitTransport(http.DefaultTransport)
+	client := &http.Client{Transport: tr}
+	for _, path := range []string{"/repos/bitrise-io/bitrise-init", "/search/issues"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		resource string
+		want     int
+	}{
+		{resource: coreResource, want: 4999},
+		{resource: searchResource, want: 29},
+	}
+	for _, tt := range tests {
+		if got, _ := tr.limit(tt.resource); got != tt.want {
+			t.Errorf("limit(%s) = %d, want %d", tt.resource, got, tt.want)
+		}
+	}
+	if got, _ := tr.Remaining(); got != 4999 {
+		t.Errorf("Remaining() = %d, want the core 4999", got)
+	}
+}
@@ -0,0 +1,124 @@
+package github
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const host = "github.com"
+
+// Repo references a GitHub repository.
+type Repo struct {
+	Owner string
+	Name  string
+}
+
+// String returns the owner/name form of the repository.
+func (r Repo) String() string {
+	return r.Owner + "/" + r.Name
+}
+
+// URL returns the HTML URL of the repository.
+func (r Repo) URL() string {
+	return "https://" + host + "/" + r.String()
+}
+
+// Key identifies the repository case-insensitively, as GitHub does.
+func (r Repo) Key() string {
+	return strings.ToLower(r.String())
+}
+
+// ParseRepo parses a GitHub repository from any form of its URL:
+// https://github.com/owner/name, with or without www, a .git suffix or a
+// trailing slash, git@github.com:owner/name.git, ssh://git@github.com/owner/name
+// and git://github.com/owner/name, or github.com/owner/name without scheme.
+// Paths below the repository, like /issues, are ignored.
+func ParseRepo(repoURL string) (Repo, error) {
+	s := strings.TrimSpace(repoURL)
+	if s == "" {
+		return Repo{}, fmt.Errorf("empty repo url")
+	}
+
+	var hostname, path string
+	if i := strings.Index(s, ":"); i > 0 && !strings.Contains(s, "://") && !strings.Contains(s[:i], "/") {
+		// scp-like syntax: [user@]host:owner/name
+		hostname, path = s[:i], s[i+1:]
+		if at := strings.LastIndex(hostname, "@"); at >= 0 {
+			hostname = hostname[at+1:]
+		}
+	} else {
+		if !strings.Contains(s, "://") {
+			s = "https://" + s
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return Repo{}, fmt.Errorf("invalid repo url %s: %s", repoURL, err)
+		}
+		hostname, path = u.Hostname(), u.Path
+	}
+
+	hostname = strings.TrimPrefix(strings.ToLower(hostname), "www.")
+	if hostname != host {
+		return Repo{}, fmt.Errorf("repo url %s is not on GitHub: host %s is not %s", repoURL, hostname, host)
+	}
+
+	fragments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(fragments) < 2 {
+		return Repo{}, fmt.Errorf("repo url %s has no owner/name path", repoURL)
+	}
+	r := Repo{Owner: fragments[0], Name: strings.TrimSuffix(fragments[1], ".git")}
+	if r.Owner == "" || r.Name == "" {
+		return Repo{}, fmt.Errorf("repo url %s has no owner/name path", repoURL)
+	}
+	return r, nil
+}
+
+// ParseIssueURL parses the repository and number of an issue from its HTML
+// URL, https://github.com/owner/name/issues/number.
+func ParseIssueURL(issueURL string) (Repo, int, error) {
+	r, err := ParseRepo(issueURL)
+	if err != nil {
+		return Repo{}, 0, err
+	}
+
+	u, err := url.Parse(strings.TrimSpace(issueURL))
+	if err != nil {
+		return Repo{}, 0, fmt.Errorf("invalid issue url %s: %s", issueURL, err)
+	}
+	fragments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(fragments) != 4 || fragments[2] != "issues" {
+		return Repo{}, 0, fmt.Errorf("not an issue url: %s", issueURL)
+	}
+	number, err := strconv.Atoi(fragments[3])
+	if err != nil {
+		return Repo{}, 0, fmt.Errorf("parse issue number of %s: %s", issueURL, err)
+	}
+	return r, number, nil
+}
+
+// ParseRepos parses the repo URLs, dropping repos listed more than once
+// regardless of URL form and case. It fails listing every URL not parsed.
+func ParseRepos(repoURLs []string) ([]Repo, error) {
+	var repos []Repo
+	var invalid []string
+	seen := map[string]bool{}
+	for _, u := range repoURLs {
+		r, err := ParseRepo(u)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		if seen[r.Key()] {
+			continue
+		}
+		seen[r.Key()] = true
+		repos = append(repos, r)
+	}
+
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid repo urls:\n- %s", strings.Join(invalid, "\n- "))
+	}
+	return repos, nil
+}
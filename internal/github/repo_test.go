@@ -0,0 +1,116 @@
+package github
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRepo(t *testing.T) {
+	tests := []struct {
+		name    string
+		repoURL string
+		want    Repo
+		wantErr bool
+	}{
+		{name: "https", repoURL: "https://github.com/bitrise-io/bitrise-init", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "http", repoURL: "http://github.com/bitrise-io/bitrise-init", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "git suffix", repoURL: "https://github.com/bitrise-io/bitrise-init.git", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "trailing slash", repoURL: "https://github.com/bitrise-io/bitrise-init/", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "sub path", repoURL: "https://github.com/bitrise-io/bitrise-init/issues/12", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "www without scheme", repoURL: "www.github.com/bitrise-io/bitrise-init/", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "scp-like", repoURL: "git@github.com:bitrise-io/bitrise-init.git", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "ssh", repoURL: "ssh://git@github.com/bitrise-io/bitrise-init.git", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "upper case host", repoURL: "https://GitHub.com/bitrise-io/bitrise-init", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "surrounding space", repoURL: "  https://github.com/bitrise-io/bitrise-init\n", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}},
+		{name: "empty", repoURL: " ", wantErr: true},
+		{name: "not on github", repoURL: "https://gitlab.com/bitrise-io/bitrise-init", wantErr: true},
+		{name: "scp-like not on github", repoURL: "git@gitlab.com:bitrise-io/bitrise-init.git", wantErr: true},
+		{name: "owner only", repoURL: "https://github.com/bitrise-io", wantErr: true},
+		{name: "empty owner", repoURL: "https://github.com//bitrise-init", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRepo(tt.repoURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRepo(%q) error = %v, wantErr %v", tt.repoURL, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRepo(%q) = %+v, want %+v", tt.repoURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRepos(t *testing.T) {
+	tests := []struct {
+		name     string
+		repoURLs []string
+		want     []Repo
+		wantErr  bool
+	}{
+		{
+			name: "duplicates in any form and case",
+			repoURLs: []string{
+				"https://github.com/bitrise-io/bitrise-init",
+				"git@github.com:bitrise-io/bitrise-init.git",
+				"www.github.com/Bitrise-IO/Bitrise-Init/",
+				"https://github.com/bitrise-steplib/steps-xcode-test",
+			},
+			want: []Repo{
+				{Owner: "bitrise-io", Name: "bitrise-init"},
+				{Owner: "bitrise-steplib", Name: "steps-xcode-test"},
+			},
+		},
+		{
+			name: "invalid url",
+			repoURLs: []string{
+				"https://github.com/bitrise-io/bitrise-init",
+				"https://gitlab.com/bitrise-io/bitrise-init",
+			},
+			wantErr: true,
+		},
+		{
+			name: "none",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRepos(tt.repoURLs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRepos(%q) error = %v, wantErr %v", tt.repoURLs, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRepos(%q) = %+v, want %+v", tt.repoURLs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIssueURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		issueURL   string
+		want       Repo
+		wantNumber int
+		wantErr    bool
+	}{
+		{name: "issue", issueURL: "https://github.com/bitrise-io/bitrise-init/issues/12", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}, wantNumber: 12},
+		{name: "trailing slash", issueURL: "https://github.com/bitrise-io/bitrise-init/issues/12/", want: Repo{Owner: "bitrise-io", Name: "bitrise-init"}, wantNumber: 12},
+		{name: "repo", issueURL: "https://github.com/bitrise-io/bitrise-init", wantErr: true},
+		{name: "pull request", issueURL: "https://github.com/bitrise-io/bitrise-init/pull/12", wantErr: true},
+		{name: "comment", issueURL: "https://github.com/bitrise-io/bitrise-init/issues/12/comments/3", wantErr: true},
+		{name: "no number", issueURL: "https://github.com/bitrise-io/bitrise-init/issues/new", wantErr: true},
+		{name: "not on github", issueURL: "https://gitlab.com/bitrise-io/bitrise-init/issues/12", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, number, err := ParseIssueURL(tt.issueURL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIssueURL(%q) error = %v, wantErr %v", tt.issueURL, err, tt.wantErr)
+			}
+			if got != tt.want || number != tt.wantNumber {
+				t.Errorf("ParseIssueURL(%q) = %+v, %d, want %+v, %d", tt.issueURL, got, number, tt.want, tt.wantNumber)
+			}
+		})
+	}
+}
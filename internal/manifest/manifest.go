@@ -0,0 +1,171 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/policy"
+	"github.com/lszucs/github-to-discourse/internal/templates"
+)
+
+// Manifest lists the repos to migrate along with their overrides.
+type Manifest struct {
+	Repos []Entry `json:"repos"`
+}
+
+// Entry is a repo of the manifest.
+type Entry struct {
+	Repo string `json:"repo"`
+	Overrides
+}
+
+// Overrides change how the issues of a repo are migrated. Unset fields keep
+// the behavior configured by flags.
+type Overrides struct {
+	// CategoryID is the discourse category the topics are posted to.
+	CategoryID int `json:"discourse_category_id,omitempty"`
+	// Tags are the discourse tags of the topics.
+	Tags []string `json:"discourse_tags,omitempty"`
+	// Policy replaces the staleness policy of the repo.
+	Policy *policy.Policy `json:"policy,omitempty"`
+	// Templates maps template names (active, stale, topic) to text/template
+	// files, relative paths being resolved against the manifest's directory.
+	Templates map[string]string `json:"templates,omitempty"`
+	// Close tells whether migrated issues are closed, defaults to true.
+	Close *bool `json:"close,omitempty"`
+	// Lock tells whether migrated issues are locked, defaults to true.
+	Lock *bool `json:"lock,omitempty"`
+}
+
+// Closes tells whether migrated issues are closed.
+func (o Overrides) Closes() bool {
+	return o.Close == nil || *o.Close
+}
+
+// Locks tells whether migrated issues are locked.
+func (o Overrides) Locks() bool {
+	return o.Lock == nil || *o.Lock
+}
+
+// Template returns the file overriding the named template, or an empty string.
+func (o Overrides) Template(name string) string {
+	return o.Templates[name]
+}
+
+var (
+	mu        sync.Mutex
+	overrides = map[string]Overrides{}
+)
+
+// Load reads the manifest at path, as YAML unless it has a .json extension,
+// registers the overrides of its repos and returns their URLs.
+func Load(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s: %s", path, err)
+	}
+
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("parse manifest %s: %s", path, err)
+		}
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest %s: %s", path, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var repoURLs []string
+	listed := map[string]bool{}
+	for _, e := range m.Repos {
+		r, err := github.ParseRepo(e.Repo)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %s", path, err)
+		}
+		if listed[r.Key()] {
+			return nil, fmt.Errorf("manifest %s: %s is listed more than once", path, r)
+		}
+		listed[r.Key()] = true
+		for name, file := range e.Templates {
+			switch name {
+			case templates.Active, templates.Stale, templates.Topic:
+			default:
+				return nil, fmt.Errorf("manifest %s: unknown template %s of %s", path, name, e.Repo)
+			}
+			// template files are relative to the manifest, not to the working directory
+			if !filepath.IsAbs(file) {
+				e.Templates[name] = filepath.Join(filepath.Dir(path), file)
+			}
+		}
+		if e.Policy != nil {
+			if err := e.Policy.Validate(); err != nil {
+				return nil, fmt.Errorf("manifest %s: policy of %s: %s", path, e.Repo, err)
+			}
+		}
+
+		overrides[r.Key()] = e.Overrides
+		repoURLs = append(repoURLs, r.URL())
+	}
+	return repoURLs, nil
+}
+
+// For returns the overrides of the repo, which are empty if it is not in a loaded manifest.
+func For(owner, name string) Overrides {
+	mu.Lock()
+	defer mu.Unlock()
+	return overrides[github.Repo{Owner: owner, Name: name}.Key()]
+}
+
+// yamlToJSON converts a YAML document to JSON, so that the manifest is
+// unmarshalled by the json tags of the types it shares with the JSON configs.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("non-string key %v", k)
+			}
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for idx, item := range v {
+			converted, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			v[idx] = converted
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
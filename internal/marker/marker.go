@@ -0,0 +1,84 @@
+package marker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	prefix = "<!-- github-to-discourse: "
+	suffix = " -->"
+)
+
+// Marker is the machine-readable note hidden in every GitHub comment and
+// Discourse topic written by the migration, so that reruns can detect them.
+type Marker struct {
+	IssueURL   string `json:"issue"`
+	TopicURL   string `json:"topic,omitempty"`
+	CommentURL string `json:"comment,omitempty"`
+}
+
+func (m Marker) String() string {
+	data, err := json.Marshal(m)
+	if err != nil {
+		// a struct of strings always marshals
+		panic(fmt.Sprintf("marshal marker: %s", err))
+	}
+	return prefix + string(data) + suffix
+}
+
+// Append adds the marker to the end of body.
+func Append(body string, m Marker) string {
+	return fmt.Sprintf("%s\n\n%s", body, m)
+}
+
+// Find returns the first marker in body.
+func Find(body string) (Marker, bool) {
+	markers := FindAll(body)
+	if len(markers) == 0 {
+		return Marker{}, false
+	}
+	return markers[0], true
+}
+
+// FindAll returns every marker in body, in order of appearance.
+func FindAll(body string) []Marker {
+	var markers []Marker
+	for {
+		start := strings.Index(body, prefix)
+		if start < 0 {
+			return markers
+		}
+		body = body[start+len(prefix):]
+		end := strings.Index(body, suffix)
+		if end < 0 {
+			return markers
+		}
+
+		var m Marker
+		if err := json.Unmarshal([]byte(body[:end]), &m); err == nil {
+			markers = append(markers, m)
+		}
+		body = body[end+len(suffix):]
+	}
+}
+
+// Strip removes every marker from body.
+func Strip(body string) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(body, prefix)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(body[start+len(prefix):], suffix)
+		if end < 0 {
+			break
+		}
+		b.WriteString(body[:start])
+		body = body[start+len(prefix)+end+len(suffix):]
+	}
+	b.WriteString(body)
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package marker
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	issueURL = "https://github.com/bitrise-io/bitrise-init/issues/12"
+	topicURL = "https://discuss.bitrise.io/t/345"
+)
+
+func TestFindAll(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Marker
+	}{
+		{
+			name: "no marker",
+			body: "Hi!\n<!-- a comment -->",
+		},
+		{
+			name: "appended",
+			body: Append("Hi!", Marker{IssueURL: issueURL, TopicURL: topicURL}),
+			want: []Marker{{IssueURL: issueURL, TopicURL: topicURL}},
+		},
+		{
+			name: "several in order",
+			body: Append(Append("Hi!", Marker{IssueURL: issueURL}), Marker{IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-1"}),
+			want: []Marker{{IssueURL: issueURL}, {IssueURL: issueURL, CommentURL: issueURL + "#issuecomment-1"}},
+		},
+		{
+			name: "invalid json skipped",
+			body: "<!-- github-to-discourse: {issue} -->\n" + Marker{IssueURL: issueURL}.String(),
+			want: []Marker{{IssueURL: issueURL}},
+		},
+		{
+			name: "unterminated",
+			body: Marker{IssueURL: issueURL}.String() + "\n<!-- github-to-discourse: {\"issue\":\"" + issueURL + "\"}",
+			want: []Marker{{IssueURL: issueURL}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindAll(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindAll(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		want   Marker
+		wantOK bool
+	}{
+		{name: "no marker", body: "Hi!"},
+		{
+			name:   "first of several",
+			body:   Append(Append("Hi!", Marker{IssueURL: issueURL, TopicURL: topicURL}), Marker{IssueURL: issueURL}),
+			want:   Marker{IssueURL: issueURL, TopicURL: topicURL},
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Find(tt.body)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Find(%q) = %+v, %v, want %+v, %v", tt.body, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "no marker", body: "Hi!", want: "Hi!"},
+		{name: "appended", body: Append("Hi!", Marker{IssueURL: issueURL}), want: "Hi!\n\n"},
+		{
+			name: "between text",
+			body: "Hi " + Marker{IssueURL: issueURL}.String() + "there" + Marker{IssueURL: issueURL, TopicURL: topicURL}.String() + "!",
+			want: "Hi there!",
+		},
+		{
+			name: "unterminated kept",
+			body: "Hi! <!-- github-to-discourse: {",
+			want: "Hi! <!-- github-to-discourse: {",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Strip(tt.body); got != tt.want {
+				t.Errorf("Strip(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package org
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/patterns"
+)
+
+const (
+	visibilityAll     = "all"
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+)
+
+var (
+	includeArchived bool
+	includeForks    bool
+	withOpenIssues  bool
+	visibility      string
+	topics          string
+	names           string
+)
+
+func init() {
+	flag.BoolVar(&includeArchived, "org-archived", false, "--org-archived (include the archived repos of the orgs)")
+	flag.BoolVar(&includeForks, "org-forks", false, "--org-forks (include the forked repos of the orgs)")
+	flag.BoolVar(&withOpenIssues, "org-with-open-issues", false, "--org-with-open-issues (only include repos of the orgs with open issues or pull requests)")
+	flag.StringVar(&visibility, "org-visibility", visibilityAll, "--org-visibility=all|public|private (only include repos of the orgs with the given visibility)")
+	flag.StringVar(&topics, "org-topics", "", "--org-topics=bitrise-step,ci (only include repos of the orgs with any of the given topics)")
+	flag.StringVar(&names, "org-repos", "", "--org-repos=steps-*,bitrise-* (only include repos of the orgs with a name matching any of the given globs)")
+}
+
+// LoadRepos lists the repos of the orgs, filtered by the --org-* flags, and
+// returns their URLs.
+func LoadRepos(ctx context.Context, orgs []string) (repoURLs []string, err error) {
+	switch visibility {
+	case visibilityAll, visibilityPublic, visibilityPrivate:
+	default:
+		return nil, fmt.Errorf("unknown --org-visibility %s", visibility)
+	}
+	for _, glob := range patterns.Split(names) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid --org-repos glob %s: %s", glob, err)
+		}
+	}
+
+	for _, o := range orgs {
+		repos, err := github.ListOrgRepos(ctx, o, visibility)
+		if err != nil {
+			return nil, fmt.Errorf("list repos of org %s: %s", o, err)
+		}
+
+		for _, r := range repos {
+			if reason := exclude(r); reason != "" {
+				log.Printf("skip repo %s: %s", r.GetFullName(), reason)
+				continue
+			}
+			repoURLs = append(repoURLs, r.GetHTMLURL())
+		}
+	}
+
+	return repoURLs, nil
+}
+
+// exclude returns why the repo is filtered out, or an empty string if it is included.
+func exclude(r *gh.Repository) string {
+	if r.GetArchived() && !includeArchived {
+		return "archived"
+	}
+	if r.GetFork() && !includeForks {
+		return "fork"
+	}
+	if withOpenIssues && r.GetOpenIssuesCount() == 0 {
+		return "no open issues"
+	}
+
+	if wanted := patterns.Split(topics); len(wanted) > 0 && !hasAnyTopic(r, wanted) {
+		return fmt.Sprintf("has none of the topics %s", strings.Join(wanted, ", "))
+	}
+
+	if globs := patterns.Split(names); len(globs) > 0 && !patterns.MatchesAny(r.GetName(), globs) {
+		return fmt.Sprintf("name matches none of %s", strings.Join(globs, ", "))
+	}
+	return ""
+}
+
+func hasAnyTopic(r *gh.Repository, wanted []string) bool {
+	for _, t := range r.Topics {
+		for _, w := range wanted {
+			if strings.EqualFold(t, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+// Package patterns holds the helpers of the flags filtering by comma
+// separated lists of glob patterns.
+package patterns
+
+import (
+	"path"
+	"strings"
+)
+
+// Split splits a comma separated flag value, dropping empty items.
+func Split(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// MatchesAny tells whether name matches any of the globs, ignoring case.
+func MatchesAny(name string, globs []string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(strings.ToLower(glob), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/github"
+)
+
+// Action is what happens to an issue.
+type Action string
+
+const (
+	// Migrate posts the issue to discourse, then comments, closes and locks it.
+	Migrate Action = "migrate"
+	// Close comments, closes and locks the issue without posting it to discourse.
+	Close Action = "close"
+	// Keep leaves the issue untouched.
+	Keep Action = "keep"
+)
+
+// Rule decides the action for issues matching all of its conditions. A rule
+// without conditions matches every issue.
+type Rule struct {
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+
+	// Labels matches issues having any of the labels.
+	Labels []string `json:"labels,omitempty"`
+	// MinComments matches issues with at least this many comments.
+	MinComments int `json:"min_comments,omitempty"`
+	// MinReactions matches issues with at least this many reactions.
+	MinReactions int `json:"min_reactions,omitempty"`
+	// MinAgeDays matches issues created at least this many days ago.
+	MinAgeDays int `json:"min_age_days,omitempty"`
+	// MaxInactiveDays matches issues updated within this many days.
+	MaxInactiveDays int `json:"max_inactive_days,omitempty"`
+	// MinInactiveDays matches issues not updated for at least this many days.
+	MinInactiveDays int `json:"min_inactive_days,omitempty"`
+	// MaxHumanInactiveDays matches issues opened or commented on by a non-bot user within this many days.
+	MaxHumanInactiveDays int `json:"max_human_inactive_days,omitempty"`
+	// MinHumanInactiveDays matches issues not opened or commented on by a non-bot user for at least this many days.
+	MinHumanInactiveDays int `json:"min_human_inactive_days,omitempty"`
+}
+
+// Policy is an ordered list of rules, the first matching rule decides.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Config holds the default policy and the policies overriding it for an org
+// ("bitrise-io") or a repo ("bitrise-io/bitrise-init").
+type Config struct {
+	Default Policy            `json:"default"`
+	Repos   map[string]Policy `json:"repos,omitempty"`
+}
+
+// InactiveRule is the rule of the default policy closing issues without
+// human activity, which the default stale comment gives as the reason.
+const InactiveRule = "no human activity for three months"
+
+// Decision is the action to take on an issue and the name of the rule which decided it.
+type Decision struct {
+	Action Action
+	Rule   string
+}
+
+var (
+	configPath string
+
+	defaultConfig = Config{
+		Default: Policy{
+			Rules: []Rule{
+				{Name: "human activity within three months", Action: Migrate, MaxHumanInactiveDays: 90},
+				{Name: InactiveRule, Action: Close},
+			},
+		},
+	}
+
+	loadOnce sync.Once
+	loadErr  error
+	config   Config
+)
+
+func init() {
+	flag.StringVar(&configPath, "policy", "", "--policy=<path> (JSON file of staleness rules per org/repo, defaults to migrating issues with non-bot activity within three months and closing the rest)")
+}
+
+func load() {
+	if configPath == "" {
+		config = defaultConfig
+		return
+	}
+
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		loadErr = fmt.Errorf("read policy %s: %s", configPath, err)
+		return
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		loadErr = fmt.Errorf("unmarshal policy %s: %s", configPath, err)
+		return
+	}
+
+	repos := map[string]Policy{}
+	for name, p := range config.Repos {
+		repos[strings.ToLower(name)] = p
+	}
+	config.Repos = repos
+
+	for _, p := range append([]Policy{config.Default}, policies(repos)...) {
+		if err := p.Validate(); err != nil {
+			loadErr = fmt.Errorf("policy %s: %s", configPath, err)
+			return
+		}
+	}
+}
+
+// Validate checks that the rules of the policy have known actions.
+func (p Policy) Validate() error {
+	for _, r := range p.Rules {
+		switch r.Action {
+		case Migrate, Close, Keep:
+		default:
+			return fmt.Errorf("rule %s: unknown action %s", r.Name, r.Action)
+		}
+	}
+	return nil
+}
+
+func policies(m map[string]Policy) []Policy {
+	var ps []Policy
+	for _, p := range m {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// For returns the policy of the repo, falling back to the policy of its org and then to the default.
+func For(owner, name string) (Policy, error) {
+	loadOnce.Do(load)
+	if loadErr != nil {
+		return Policy{}, loadErr
+	}
+
+	if p, ok := config.Repos[strings.ToLower(owner+"/"+name)]; ok {
+		return p, nil
+	}
+	if p, ok := config.Repos[strings.ToLower(owner)]; ok {
+		return p, nil
+	}
+	return config.Default, nil
+}
+
+// Decide returns the decision of the policy of the issue's repo.
+func Decide(ctx context.Context, i *gh.Issue) (Decision, error) {
+	p, err := For(github.IssueRepo(i))
+	if err != nil {
+		return Decision{}, err
+	}
+	return p.Decide(ctx, i)
+}
+
+// Decide returns the action of the first matching rule, or Keep if none matches.
+func (p Policy) Decide(ctx context.Context, i *gh.Issue) (Decision, error) {
+	f := facts{ctx: ctx, issue: i}
+	for _, r := range p.Rules {
+		ok, err := r.matches(&f)
+		if err != nil {
+			return Decision{}, fmt.Errorf("evaluate rule %s: %s", r.Name, err)
+		}
+		if ok {
+			return Decision{Action: r.Action, Rule: r.Name}, nil
+		}
+	}
+	return Decision{Action: Keep, Rule: "no matching rule"}, nil
+}
+
+// facts evaluates the properties of an issue, fetching the expensive ones only once and only if needed.
+type facts struct {
+	ctx   context.Context
+	issue *gh.Issue
+
+	humanActivityFetched bool
+	lastHumanActivity    time.Time
+}
+
+func (f *facts) daysSince(t time.Time) int {
+	return int(time.Since(t).Hours() / 24)
+}
+
+func (f *facts) humanInactiveDays() (int, error) {
+	if !f.humanActivityFetched {
+		last, err := github.LastHumanActivity(f.ctx, f.issue)
+		if err != nil {
+			return 0, err
+		}
+		f.lastHumanActivity = last
+		f.humanActivityFetched = true
+	}
+	return f.daysSince(f.lastHumanActivity), nil
+}
+
+func (r Rule) matches(f *facts) (bool, error) {
+	i := f.issue
+	if len(r.Labels) > 0 && !hasAnyLabel(i, r.Labels) {
+		return false, nil
+	}
+	if r.MinComments > 0 && i.GetComments() < r.MinComments {
+		return false, nil
+	}
+	if r.MinReactions > 0 && i.GetReactions().GetTotalCount() < r.MinReactions {
+		return false, nil
+	}
+	if r.MinAgeDays > 0 && f.daysSince(i.GetCreatedAt()) < r.MinAgeDays {
+		return false, nil
+	}
+	if r.MaxInactiveDays > 0 && f.daysSince(i.GetUpdatedAt()) >= r.MaxInactiveDays {
+		return false, nil
+	}
+	if r.MinInactiveDays > 0 && f.daysSince(i.GetUpdatedAt()) < r.MinInactiveDays {
+		return false, nil
+	}
+	if r.MaxHumanInactiveDays > 0 || r.MinHumanInactiveDays > 0 {
+		days, err := f.humanInactiveDays()
+		if err != nil {
+			return false, err
+		}
+		if r.MaxHumanInactiveDays > 0 && days >= r.MaxHumanInactiveDays {
+			return false, nil
+		}
+		if r.MinHumanInactiveDays > 0 && days < r.MinHumanInactiveDays {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func hasAnyLabel(i *gh.Issue, labels []string) bool {
+	for _, l := range i.Labels {
+		for _, want := range labels {
+			if strings.EqualFold(l.GetName(), want) {
+				return true
+			}
+		}
+	}
+	return false
+}
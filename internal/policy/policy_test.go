@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gh "github.com/google/go-github/github"
+)
+
+func daysAgo(days int) time.Time {
+	return time.Now().Add(-time.Duration(days)*24*time.Hour - time.Hour)
+}
+
+func issue(labels []string, comments, reactions, ageDays, inactiveDays int) *gh.Issue {
+	createdAt, updatedAt := daysAgo(ageDays), daysAgo(inactiveDays)
+	i := &gh.Issue{
+		Comments:  gh.Int(comments),
+		Reactions: &gh.Reactions{TotalCount: gh.Int(reactions)},
+		CreatedAt: &createdAt,
+		UpdatedAt: &updatedAt,
+	}
+	for _, l := range labels {
+		i.Labels = append(i.Labels, gh.Label{Name: gh.String(l)})
+	}
+	return i
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name              string
+		rule              Rule
+		issue             *gh.Issue
+		humanInactiveDays int
+		want              bool
+	}{
+		{name: "no conditions", rule: Rule{}, issue: issue(nil, 0, 0, 0, 0), want: true},
+		{name: "label", rule: Rule{Labels: []string{"bug", "crash"}}, issue: issue([]string{"Bug"}, 0, 0, 0, 0), want: true},
+		{name: "no label", rule: Rule{Labels: []string{"bug"}}, issue: issue([]string{"question"}, 0, 0, 0, 0), want: false},
+		{name: "min comments", rule: Rule{MinComments: 3}, issue: issue(nil, 3, 0, 0, 0), want: true},
+		{name: "too few comments", rule: Rule{MinComments: 3}, issue: issue(nil, 2, 0, 0, 0), want: false},
+		{name: "min reactions", rule: Rule{MinReactions: 5}, issue: issue(nil, 0, 5, 0, 0), want: true},
+		{name: "too few reactions", rule: Rule{MinReactions: 5}, issue: issue(nil, 0, 4, 0, 0), want: false},
+		{name: "min age", rule: Rule{MinAgeDays: 30}, issue: issue(nil, 0, 0, 30, 0), want: true},
+		{name: "too young", rule: Rule{MinAgeDays: 30}, issue: issue(nil, 0, 0, 29, 0), want: false},
+		{name: "updated within", rule: Rule{MaxInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 89), want: true},
+		{name: "not updated within", rule: Rule{MaxInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 90), want: false},
+		{name: "inactive", rule: Rule{MinInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 90), want: true},
+		{name: "not inactive", rule: Rule{MinInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 89), want: false},
+		{name: "human activity within", rule: Rule{MaxHumanInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 0), humanInactiveDays: 89, want: true},
+		{name: "no human activity within", rule: Rule{MaxHumanInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 0), humanInactiveDays: 90, want: false},
+		{name: "human inactive", rule: Rule{MinHumanInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 0), humanInactiveDays: 90, want: true},
+		{name: "not human inactive", rule: Rule{MinHumanInactiveDays: 90}, issue: issue(nil, 0, 0, 100, 0), humanInactiveDays: 89, want: false},
+		{name: "all conditions", rule: Rule{Labels: []string{"bug"}, MinComments: 1, MinAgeDays: 10}, issue: issue([]string{"bug"}, 1, 0, 10, 0), want: true},
+		{name: "one condition failing", rule: Rule{Labels: []string{"bug"}, MinComments: 1, MinAgeDays: 10}, issue: issue([]string{"bug"}, 0, 0, 10, 0), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// human activity is preset, as fetching it needs the GitHub API
+			f := facts{
+				ctx:                  context.Background(),
+				issue:                tt.issue,
+				humanActivityFetched: true,
+				lastHumanActivity:    daysAgo(tt.humanInactiveDays),
+			}
+			got, err := tt.rule.matches(&f)
+			if err != nil {
+				t.Fatalf("matches() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyDecide(t *testing.T) {
+	p := Policy{
+		Rules: []Rule{
+			{Name: "keep feature requests", Action: Keep, Labels: []string{"feature"}},
+			{Name: "popular", Action: Migrate, MinReactions: 5},
+			{Name: "old", Action: Close, MinInactiveDays: 90},
+		},
+	}
+	tests := []struct {
+		name  string
+		issue *gh.Issue
+		want  Decision
+	}{
+		{name: "first matching rule wins", issue: issue([]string{"feature"}, 0, 10, 100, 100), want: Decision{Action: Keep, Rule: "keep feature requests"}},
+		{name: "later rule", issue: issue(nil, 0, 10, 100, 100), want: Decision{Action: Migrate, Rule: "popular"}},
+		{name: "last rule", issue: issue(nil, 0, 0, 100, 100), want: Decision{Action: Close, Rule: "old"}},
+		{name: "no matching rule", issue: issue(nil, 0, 0, 10, 10), want: Decision{Action: Keep, Rule: "no matching rule"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Decide(context.Background(), tt.issue)
+			if err != nil {
+				t.Fatalf("Decide() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Decide() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{name: "known actions", policy: Policy{Rules: []Rule{{Action: Migrate}, {Action: Close}, {Action: Keep}}}},
+		{name: "no rules", policy: Policy{}},
+		{name: "unknown action", policy: Policy{Rules: []Rule{{Name: "archive", Action: "archive"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Package retry holds the waiting helpers shared by the API clients when
+// retrying rate limited or failed requests.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	baseBackoff = time.Second
+	maxBackoff  = time.Minute
+)
+
+// Sleep waits for d or until ctx is done, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Backoff returns the exponential backoff with jitter for the given attempt.
+func Backoff(attempt int) time.Duration {
+	wait := baseBackoff << uint(attempt)
+	if wait > maxBackoff || wait <= 0 {
+		wait = maxBackoff
+	}
+	return wait + Jitter()
+}
+
+// Jitter returns a random wait of up to a second, added to waits so that
+// concurrent retries do not hit the API at once.
+func Jitter() time.Duration {
+	return time.Duration(rand.Int63n(int64(baseBackoff)))
+}
@@ -0,0 +1,242 @@
+package runmode
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+
+	"github.com/lszucs/github-to-discourse/internal/manifest"
+)
+
+const defaultJournalPath = "migration-journal.json"
+
+var journalPath string
+
+func init() {
+	flag.StringVar(&journalPath, "journal", defaultJournalPath, "--journal=<path> (file recording migration progress per issue, used to resume interrupted live runs)")
+}
+
+type step int
+
+const (
+	discourseDone step = iota
+	repliesDone
+	commentDone
+	closeDone
+	lockDone
+)
+
+func (s step) String() string {
+	switch s {
+	case discourseDone:
+		return "discourse"
+	case repliesDone:
+		return "replies"
+	case commentDone:
+		return "comment"
+	case closeDone:
+		return "close"
+	case lockDone:
+		return "lock"
+	default:
+		return fmt.Sprintf("step(%d)", int(s))
+	}
+}
+
+type journalEntry struct {
+	DiscourseURL string `json:"discourse_url,omitempty"`
+	// CategoryID, Title and Tags are those the topic was planned to be
+	// posted with, StepID the steplib step of the issue's repo, so that later
+	// runs do not depend on the configuration the topic was planned with.
+	CategoryID int      `json:"category_id,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	StepID     string   `json:"step_id,omitempty"`
+	// Close and Lock tell whether the issue was planned to be closed and
+	// locked, unset in journals written before they were recorded.
+	Close *bool    `json:"close,omitempty"`
+	Lock  *bool    `json:"lock,omitempty"`
+	Done  []string `json:"done,omitempty"`
+}
+
+// closes tells whether the issue is closed by the migration, as recorded,
+// or as the overrides of its repo say otherwise.
+func (e journalEntry) closes(o manifest.Overrides) bool {
+	if e.Close != nil {
+		return *e.Close
+	}
+	return o.Closes()
+}
+
+// locks tells whether the issue is locked by the migration, as recorded,
+// or as the overrides of its repo say otherwise.
+func (e journalEntry) locks(o manifest.Overrides) bool {
+	if e.Lock != nil {
+		return *e.Lock
+	}
+	return o.Locks()
+}
+
+// journal persists the completed migration steps of each issue, keyed by
+// the issue's HTML URL, so that a rerun can pick up where the last one stopped.
+type journal struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*journalEntry
+}
+
+func openJournal(path string) (*journal, error) {
+	j := &journal{
+		path:    path,
+		entries: map[string]*journalEntry{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read journal %s: %s", path, err)
+	}
+
+	if err := json.Unmarshal(data, &j.entries); err != nil {
+		return nil, fmt.Errorf("unmarshal journal %s: %s", path, err)
+	}
+	log.Printf("resuming from journal %s with %d issues", path, len(j.entries))
+
+	return j, nil
+}
+
+// entry returns the entry of the issue, creating it if needed. j.mu must be held.
+func (j *journal) entry(issueURL string) *journalEntry {
+	e, ok := j.entries[issueURL]
+	if !ok {
+		e = &journalEntry{}
+		j.entries[issueURL] = e
+	}
+	return e
+}
+
+func (j *journal) isDone(issueURL string, s step) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[issueURL]
+	if !ok {
+		return false
+	}
+	for _, d := range e.Done {
+		if d == s.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *journal) discourseURL(issueURL string) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e, ok := j.entries[issueURL]; ok {
+		return e.DiscourseURL
+	}
+	return ""
+}
+
+func (j *journal) setDiscourseURL(issueURL, discourseURL string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entry(issueURL).DiscourseURL = discourseURL
+}
+
+// setTopic records the topic of the issue along with how it was planned to be posted.
+func (j *journal) setTopic(issueURL, discourseURL string, p PlannedIssue) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.entry(issueURL)
+	e.DiscourseURL = discourseURL
+	e.CategoryID = p.Topic.CategoryID
+	e.Title = p.Topic.Title
+	e.Tags = p.Topic.Tags
+	e.StepID = p.StepID
+}
+
+// setClosing records whether the issue is planned to be closed and locked.
+func (j *journal) setClosing(issueURL string, p PlannedIssue) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.entry(issueURL)
+	closeIssue, lockIssue := !p.KeepOpen, !p.KeepUnlocked
+	e.Close, e.Lock = &closeIssue, &lockIssue
+}
+
+// get returns a copy of the entry of the issue.
+func (j *journal) get(issueURL string) (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[issueURL]
+	if !ok {
+		return journalEntry{}, false
+	}
+	return *e, true
+}
+
+func (j *journal) markDone(issueURL string, s step) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := j.entry(issueURL)
+	e.Done = append(e.Done, s.String())
+	return j.save()
+}
+
+// list returns a copy of the journal entries keyed by issue URL.
+func (j *journal) list() map[string]journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := map[string]journalEntry{}
+	for url, e := range j.entries {
+		entries[url] = *e
+	}
+	return entries
+}
+
+// forget removes the issue from the journal, so it is migrated from scratch by a later run.
+func (j *journal) forget(issueURL string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.entries, issueURL)
+	return j.save()
+}
+
+// do runs fn unless step s is already recorded for the issue, and records it on success.
+func (j *journal) do(issueURL string, s step, fn func() error) error {
+	if j.isDone(issueURL, s) {
+		log.Printf("%s: skip %s step: already done", issueURL, s)
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return j.markDone(issueURL, s)
+}
+
+// save writes the journal to disk. j.mu must be held.
+func (j *journal) save() error {
+	data, err := json.MarshalIndent(j.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal journal: %s", err)
+	}
+
+	tmp := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write journal %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("replace journal %s: %s", j.path, err)
+	}
+	return nil
+}
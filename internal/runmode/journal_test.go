@@ -0,0 +1,215 @@
+package runmode
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	gh "github.com/google/go-github/github"
+)
+
+const testIssueURL = "https://github.com/bitrise-io/bitrise-init/issues/12"
+
+func tempJournal(t *testing.T) (*journal, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j, err := openJournal(path)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	return j, path
+}
+
+func TestJournalDo(t *testing.T) {
+	fail := errors.New("post failed")
+	tests := []struct {
+		name     string
+		done     []step
+		fnErr    error
+		wantRun  bool
+		wantErr  error
+		wantDone bool
+	}{
+		{name: "runs and records a new step", wantRun: true, wantDone: true},
+		{name: "skips a recorded step", done: []step{discourseDone}, wantRun: false, wantDone: true},
+		{name: "records nothing on failure", fnErr: fail, wantRun: true, wantErr: fail, wantDone: false},
+		{name: "other steps do not count", done: []step{repliesDone, commentDone}, wantRun: true, wantDone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, path := tempJournal(t)
+			for _, s := range tt.done {
+				if err := j.markDone(testIssueURL, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ran := false
+			err := j.do(testIssueURL, discourseDone, func() error {
+				ran = true
+				return tt.fnErr
+			})
+			if err != tt.wantErr {
+				t.Errorf("do() error = %v, want %v", err, tt.wantErr)
+			}
+			if ran != tt.wantRun {
+				t.Errorf("do() ran fn = %v, want %v", ran, tt.wantRun)
+			}
+			if got := j.isDone(testIssueURL, discourseDone); got != tt.wantDone {
+				t.Errorf("isDone() = %v, want %v", got, tt.wantDone)
+			}
+
+			// what a rerun sees
+			reopened, err := openJournal(path)
+			if err != nil {
+				t.Fatalf("openJournal() error = %v", err)
+			}
+			if got := reopened.isDone(testIssueURL, discourseDone); got != tt.wantDone {
+				t.Errorf("isDone() after reopening = %v, want %v", got, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	j, path := tempJournal(t)
+	p := PlannedIssue{
+		URL:      testIssueURL,
+		StepID:   "xcode-test",
+		KeepOpen: true,
+		Topic: &PlannedTopic{
+			Title:      "[Xcode Test] crash",
+			CategoryID: 12,
+			Tags:       []string{"xcode-test"},
+		},
+	}
+	j.setTopic(testIssueURL, "https://discuss.bitrise.io/t/34", p)
+	j.setClosing(testIssueURL, p)
+	for _, s := range []step{discourseDone, repliesDone} {
+		if err := j.markDone(testIssueURL, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.markDone("https://github.com/bitrise-io/bitrise-init/issues/13", discourseDone); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary journal %s.tmp left behind: %v", path, err)
+	}
+
+	reopened, err := openJournal(path)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	if !reflect.DeepEqual(reopened.list(), j.list()) {
+		t.Errorf("reopened journal = %+v, want %+v", reopened.list(), j.list())
+	}
+
+	e, ok := reopened.get(testIssueURL)
+	if !ok {
+		t.Fatalf("issue missing from the reopened journal")
+	}
+	if e.DiscourseURL != "https://discuss.bitrise.io/t/34" || e.CategoryID != 12 || e.StepID != "xcode-test" {
+		t.Errorf("reopened entry = %+v, want the recorded topic", e)
+	}
+	if e.Close == nil || *e.Close || e.Lock == nil || !*e.Lock {
+		t.Errorf("reopened entry close/lock = %v/%v, want false/true", e.Close, e.Lock)
+	}
+	if !reflect.DeepEqual(e.Done, []string{"discourse", "replies"}) {
+		t.Errorf("reopened entry done = %v, want discourse and replies", e.Done)
+	}
+
+	if err := reopened.forget(testIssueURL); err != nil {
+		t.Fatal(err)
+	}
+	again, err := openJournal(path)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	if _, ok := again.get(testIssueURL); ok {
+		t.Errorf("forgotten issue still in the journal")
+	}
+	if len(again.list()) != 1 {
+		t.Errorf("journal has %d issues after forgetting one of 2", len(again.list()))
+	}
+}
+
+func TestOpenJournalInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openJournal(path); err == nil {
+		t.Errorf("openJournal() of a truncated journal succeeded")
+	}
+}
+
+func TestJournalReplacesStaleTemporaryFile(t *testing.T) {
+	j, path := tempJournal(t)
+	// left behind by a run killed while saving
+	if err := ioutil.WriteFile(path+".tmp", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.markDone(testIssueURL, discourseDone); err != nil {
+		t.Fatalf("markDone() error = %v", err)
+	}
+	reopened, err := openJournal(path)
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	if !reopened.isDone(testIssueURL, discourseDone) {
+		t.Errorf("step recorded after a stale temporary file is missing")
+	}
+}
+
+// TestExecuteStepOrder runs the steps which need neither GitHub nor
+// discourse: issues kept open and unlocked with their API steps recorded.
+func TestExecuteStepOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        PlannedIssue
+		recorded []step
+		want     []string
+	}{
+		{
+			name:     "active",
+			p:        PlannedIssue{URL: testIssueURL, Classification: classActive, Topic: &PlannedTopic{}, KeepOpen: true, KeepUnlocked: true},
+			recorded: []step{discourseDone, repliesDone, commentDone},
+			want:     []string{"discourse", "replies", "comment", "close", "lock"},
+		},
+		{
+			name:     "stale",
+			p:        PlannedIssue{URL: testIssueURL, Classification: classStale, KeepOpen: true, KeepUnlocked: true},
+			recorded: []step{commentDone},
+			want:     []string{"comment", "close", "lock"},
+		},
+		{
+			name: "kept",
+			p:    PlannedIssue{URL: testIssueURL, Classification: classKept},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, _ := tempJournal(t)
+			for _, s := range tt.recorded {
+				if err := j.markDone(testIssueURL, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			i := &gh.Issue{HTMLURL: gh.String(testIssueURL), State: gh.String("open")}
+			if err := execute(context.Background(), j, i, tt.p); err != nil {
+				t.Fatalf("execute() error = %v", err)
+			}
+			e, _ := j.get(testIssueURL)
+			if !reflect.DeepEqual(e.Done, tt.want) {
+				t.Errorf("done steps = %v, want %v", e.Done, tt.want)
+			}
+		})
+	}
+}
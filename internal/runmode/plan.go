@@ -0,0 +1,227 @@
+package runmode
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/manifest"
+	"github.com/lszucs/github-to-discourse/internal/policy"
+	"github.com/lszucs/github-to-discourse/internal/steplib"
+	"github.com/lszucs/github-to-discourse/internal/templates"
+)
+
+const (
+	defaultPlanPath = "migration-plan.json"
+
+	classPullRequest = "pull request"
+	classActive      = "active"
+	classStale       = "stale"
+	classKept        = "kept"
+
+	// topicURLPlaceholder stands in for the URL of the discourse topic in
+	// planned comments, as it is only known once the topic is created.
+	topicURLPlaceholder = "{{discourse_topic_url}}"
+)
+
+var planPath string
+
+func init() {
+	flag.StringVar(&planPath, "plan", defaultPlanPath, "--plan=<path> (plan file written by dry mode and executed by apply mode)")
+}
+
+// Plan lists every issue of a dry run along with the exact actions a live
+// run takes on it.
+type Plan struct {
+	Issues []PlannedIssue `json:"issues"`
+}
+
+type PlannedIssue struct {
+	URL            string         `json:"url"`
+	Repo           string         `json:"repo"`
+	Number         int            `json:"number"`
+	Classification string         `json:"classification"`
+	Rule           string         `json:"rule,omitempty"`
+	Topic          *PlannedTopic  `json:"topic,omitempty"`
+	Replies        []PlannedReply `json:"replies,omitempty"`
+	Comment        string         `json:"comment,omitempty"`
+	// StepID is the steplib step of the issue's repo, resolved when planning.
+	StepID string `json:"step_id,omitempty"`
+	// KeepOpen and KeepUnlocked skip closing and locking the issue after commenting.
+	KeepOpen     bool `json:"keep_open,omitempty"`
+	KeepUnlocked bool `json:"keep_unlocked,omitempty"`
+}
+
+type PlannedTopic struct {
+	Title      string   `json:"title"`
+	Body       string   `json:"body"`
+	CategoryID int      `json:"category_id"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+type PlannedReply struct {
+	CommentURL string `json:"comment_url"`
+	Body       string `json:"body"`
+}
+
+// planIssue computes the actions to take on the issue according to the
+// decision of its policy, the manifest overrides of its repo and the loaded
+// steplib step of its repo.
+func planIssue(ctx context.Context, i *gh.Issue, d policy.Decision) (PlannedIssue, error) {
+	step, isStep := steplib.For(github.IssueRepo(i))
+	return planStepIssue(ctx, i, d, step, isStep)
+}
+
+// planStepIssue is planIssue with the steplib step of the issue's repo
+// given, e.g. as recorded in the journal.
+func planStepIssue(ctx context.Context, i *gh.Issue, d policy.Decision, step steplib.Step, isStep bool) (PlannedIssue, error) {
+	owner, name := github.IssueRepo(i)
+	o := manifest.For(owner, name)
+	p := PlannedIssue{
+		URL:          i.GetHTMLURL(),
+		Repo:         fmt.Sprintf("%s/%s", owner, name),
+		Number:       i.GetNumber(),
+		KeepOpen:     !o.Closes(),
+		KeepUnlocked: !o.Locks(),
+	}
+
+	if i.IsPullRequest() {
+		p.Classification = classPullRequest
+		return p, nil
+	}
+
+	p.Rule = d.Rule
+	data := templates.NewData(i)
+	data.Rule = d.Rule
+	if isStep {
+		data.StepID = step.ID
+		p.StepID = step.ID
+	}
+	switch d.Action {
+	case policy.Keep:
+		p.Classification = classKept
+		return p, nil
+	case policy.Close:
+		p.Classification = classStale
+		comment, err := templates.RenderFile(templates.Stale, o.Template(templates.Stale), data)
+		if err != nil {
+			return p, err
+		}
+		p.Comment = comment
+		return p, nil
+	}
+
+	p.Classification = classActive
+	body, err := templates.RenderFile(templates.Topic, o.Template(templates.Topic), data)
+	if err != nil {
+		return p, err
+	}
+	p.Topic = &PlannedTopic{
+		Title:      i.GetTitle(),
+		Body:       body,
+		CategoryID: topicCategoryID(owner, name),
+		Tags:       append([]string{}, o.Tags...),
+	}
+	if isStep {
+		p.Topic.Title = fmt.Sprintf("[%s] %s", step.Title(), p.Topic.Title)
+		p.Topic.Tags = append(p.Topic.Tags, step.ID)
+	}
+
+	data.DiscourseURL = topicURLPlaceholder
+	comment, err := templates.RenderFile(templates.Active, o.Template(templates.Active), data)
+	if err != nil {
+		return p, err
+	}
+	p.Comment = comment
+
+	if i.GetComments() == 0 {
+		return p, nil
+	}
+	comments, err := github.GetComments(ctx, i)
+	if err != nil {
+		return p, err
+	}
+	for _, c := range comments {
+		if github.IsMigrationComment(c) {
+			continue
+		}
+		p.Replies = append(p.Replies, PlannedReply{
+			CommentURL: c.GetHTMLURL(),
+			Body: discourse.Reply{
+				Author:    c.GetUser().GetLogin(),
+				CreatedAt: c.GetCreatedAt(),
+				URL:       c.GetHTMLURL(),
+				Body:      c.GetBody(),
+			}.Raw(),
+		})
+	}
+	return p, nil
+}
+
+// topicCategoryID returns the discourse category of the repo's topics: the
+// one of its manifest overrides, of its steplib step or the default one.
+func topicCategoryID(owner, name string) int {
+	if o := manifest.For(owner, name); o.CategoryID != 0 {
+		return o.CategoryID
+	}
+	if step, ok := steplib.For(owner, name); ok && step.CategoryID != 0 {
+		return step.CategoryID
+	}
+	return discourse.CategoryID()
+}
+
+// comment returns the planned comment with the topic URL filled in.
+func (p PlannedIssue) comment(topicURL string) string {
+	return strings.Replace(p.Comment, topicURLPlaceholder, topicURL, -1)
+}
+
+func writePlan(path string, plan Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal plan: %s", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("write plan %s: %s", path, err)
+	}
+	return nil
+}
+
+func readPlan(path string) (Plan, error) {
+	var plan Plan
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return plan, fmt.Errorf("read plan %s: %s", path, err)
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return plan, fmt.Errorf("unmarshal plan %s: %s", path, err)
+	}
+	return plan, nil
+}
+
+// Preview prints what the migration would post for the issue, as both an
+// active and a stale issue.
+func Preview(ctx context.Context, i *gh.Issue) error {
+	active, err := planIssue(ctx, i, policy.Decision{Action: policy.Migrate})
+	if err != nil {
+		return err
+	}
+	stale, err := planIssue(ctx, i, policy.Decision{Action: policy.Close})
+	if err != nil {
+		return err
+	}
+
+	if active.Topic != nil {
+		fmt.Printf("discourse topic (category %d): %s\n\n%s\n\n", active.Topic.CategoryID, active.Topic.Title, active.Topic.Body)
+	}
+	// the topic does not exist yet, so the comment links an example one
+	fmt.Printf("comment if active:\n\n%s\n\n", active.comment(discourse.BaseURL()+"/t/1234"))
+	fmt.Printf("comment if stale:\n\n%s\n", stale.Comment)
+	return nil
+}
@@ -0,0 +1,212 @@
+package runmode
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/github"
+)
+
+const (
+	defaultConcurrency     = 4
+	defaultRepoConcurrency = 1
+)
+
+var (
+	concurrency     int
+	repoConcurrency int
+)
+
+func init() {
+	flag.IntVar(&concurrency, "concurrency", defaultConcurrency, "--concurrency=<int> (number of issues processed at the same time)")
+	flag.IntVar(&repoConcurrency, "repo-concurrency", defaultRepoConcurrency, "--repo-concurrency=<int> (number of issues of the same repo processed at the same time)")
+}
+
+// pool runs jobs concurrently, at most concurrency of them overall and at
+// most repoConcurrency of them per repo. Each job processes a single issue,
+// so the steps of an issue stay in order.
+type pool struct {
+	global chan struct{}
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	repos map[string]chan struct{}
+}
+
+func newPool() *pool {
+	return &pool{
+		global: make(chan struct{}, atLeastOne(concurrency)),
+		repos:  map[string]chan struct{}{},
+	}
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (p *pool) repo(name string) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sem, ok := p.repos[name]
+	if !ok {
+		sem = make(chan struct{}, atLeastOne(repoConcurrency))
+		p.repos[name] = sem
+	}
+	return sem
+}
+
+func (p *pool) run(repo string, job func()) {
+	sem := p.repo(repo)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		p.global <- struct{}{}
+		defer func() { <-p.global }()
+		job()
+	}()
+}
+
+func (p *pool) wait() {
+	p.wg.Wait()
+}
+
+// issueJob processes the issue at issueURL, which get returns.
+type issueJob func(issueURL string, get func(ctx context.Context) (*gh.Issue, error))
+
+// runIssues runs job for the issues of the journal if fromJournal is set,
+// fetching each when get is called, and for the given issues but pull
+// requests otherwise.
+func (p *pool) runIssues(j *journal, fromJournal bool, issues []*gh.Issue, job issueJob) {
+	if fromJournal {
+		for issueURL := range j.list() {
+			issueURL := issueURL
+			p.run(issueURLRepo(issueURL), func() {
+				job(issueURL, func(ctx context.Context) (*gh.Issue, error) {
+					return github.GetIssueByURL(ctx, issueURL)
+				})
+			})
+		}
+		return
+	}
+
+	for _, i := range issues {
+		i := i
+		if i.IsPullRequest() {
+			continue
+		}
+		p.run(issueURLRepo(i.GetHTMLURL()), func() {
+			job(i.GetHTMLURL(), func(context.Context) (*gh.Issue, error) {
+				return i, nil
+			})
+		})
+	}
+}
+
+// issueURLRepo returns the owner/name of the repo of the issue, the key
+// issues of the same repo share in the pool.
+func issueURLRepo(issueURL string) string {
+	r, err := github.ParseRepo(issueURL)
+	if err != nil {
+		return issueURL
+	}
+	return r.String()
+}
+
+// batch tracks the stats and failures of migrating a list of issues
+// processed concurrently.
+type batch struct {
+	ctx context.Context
+
+	mu         sync.Mutex
+	notStarted []string
+	stats      Stats
+	failures   []Failure
+	err        error
+}
+
+func (b *batch) count(fn func(s *Stats)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fn(&b.stats)
+}
+
+// aborted tells whether an issue failed while not continuing on error, so no new issue should be started.
+func (b *batch) aborted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err != nil
+}
+
+// process runs migrate for the issue and counts it by the classification it
+// returns, or records its failure: moving on when continuing on error and
+// aborting the batch otherwise. Once the batch's context is cancelled no new
+// issue is started, but migrate gets a context which is not cancelled along
+// with it, so that the steps of an issue in flight are not left half done.
+func (b *batch) process(issueURL string, migrate func(ctx context.Context) (string, error)) {
+	if b.aborted() {
+		return
+	}
+	if b.ctx.Err() != nil {
+		b.mu.Lock()
+		b.notStarted = append(b.notStarted, issueURL)
+		b.mu.Unlock()
+		return
+	}
+
+	class, err := migrate(context.WithoutCancel(b.ctx))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		if !continueOnError {
+			if b.err == nil {
+				b.err = err
+			}
+			return
+		}
+		log.Errorf("failed to migrate %s: %s", issueURL, err)
+		b.stats.Failed++
+		b.failures = append(b.failures, newFailure(issueURL, err))
+		return
+	}
+
+	switch class {
+	case classActive:
+		b.stats.Active++
+	case classStale:
+		b.stats.Stale++
+	case classKept:
+		b.stats.Kept++
+	}
+	b.stats.Processed++
+}
+
+func (b *batch) finish() (Stats, error) {
+	if b.err != nil {
+		return b.stats, b.err
+	}
+
+	var interrupted error
+	if b.ctx.Err() != nil {
+		log.Warnf("interrupted: %d issues done, %d failed, %d not started:", b.stats.Processed, b.stats.Failed, len(b.notStarted))
+		for _, u := range b.notStarted {
+			log.Printf("- %s", u)
+		}
+		interrupted = fmt.Errorf("interrupted, %d issues not started", len(b.notStarted))
+	}
+
+	if err := report(b.failures); err != nil {
+		return b.stats, err
+	}
+	return b.stats, interrupted
+}
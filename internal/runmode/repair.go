@@ -0,0 +1,139 @@
+package runmode
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/manifest"
+	"github.com/lszucs/github-to-discourse/internal/policy"
+	"github.com/lszucs/github-to-discourse/internal/steplib"
+)
+
+var repairSearchTopics bool
+
+func init() {
+	flag.BoolVar(&repairSearchTopics, "repair-search-topics", false, "--repair-search-topics (search discourse for the topic of open issues without a migration comment and unknown to the journal, one search per issue)")
+}
+
+// Repair completes the migration of partially migrated issues: issues with a
+// discourse topic but no migration comment, with a migration comment but
+// still open, or closed but not locked. Topics and comments which already
+// exist are never created again. It repairs the issues of the journal if
+// fromJournal is set, and the given issues otherwise.
+func Repair(ctx context.Context, fromJournal bool, issues []*gh.Issue) (Stats, error) {
+	j, err := openJournal(journalPath)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if !fromJournal && len(issues) == 0 {
+		log.Printf("no issues to repair")
+		return Stats{}, nil
+	}
+
+	b := batch{ctx: ctx}
+	pool := newPool()
+	pool.runIssues(j, fromJournal, issues, func(issueURL string, get func(ctx context.Context) (*gh.Issue, error)) {
+		b.process(issueURL, func(ctx context.Context) (string, error) {
+			i, err := get(ctx)
+			if err != nil {
+				return "", err
+			}
+			return repair(ctx, j, i)
+		})
+	})
+	pool.wait()
+	return b.finish()
+}
+
+// repair detects which migration steps were done on the issue and runs the
+// missing ones, returning the classification the issue was migrated with.
+func repair(ctx context.Context, j *journal, i *gh.Issue) (string, error) {
+	issueURL := i.GetHTMLURL()
+	c, m, err := github.FindMigrationComment(ctx, i)
+	if err != nil {
+		return "", err
+	}
+
+	topicURL := j.discourseURL(issueURL)
+	if topicURL == "" {
+		topicURL = m.TopicURL
+	}
+	// a topic without a comment is only searched for on open issues, as the
+	// issue is closed after commenting, and only if asked to, as most issues
+	// of a repo have no topic and each search spends the search budget
+	if repairSearchTopics && topicURL == "" && c == nil && i.GetState() != "closed" {
+		if topicURL, err = discourse.FindTopic(ctx, issueURL); err != nil {
+			return "", err
+		}
+	}
+
+	// issues planned to be kept open or unlocked, as the journal records or
+	// the manifest says, count as closed or locked
+	entry, _ := j.get(issueURL)
+	o := manifest.For(github.IssueRepo(i))
+	closed, locked := i.GetState() == "closed" || !entry.closes(o), i.GetLocked() || !entry.locks(o)
+	switch {
+	case topicURL == "" && c == nil:
+		log.Printf("skip %s: not migrated", issueURL)
+		return "", nil
+	case c != nil && closed && locked:
+		log.Printf("skip %s: fully migrated", issueURL)
+		return classForTopic(topicURL), nil
+	case c == nil:
+		log.Infof("repair %s: topic %s exists but no comment", issueURL, topicURL)
+	case !closed:
+		log.Infof("repair %s: commented but not closed", issueURL)
+	default:
+		log.Infof("repair %s: closed but not locked", issueURL)
+	}
+
+	// record what is already done, so that execute only runs the missing steps
+	if topicURL != "" && !j.isDone(issueURL, discourseDone) {
+		j.setDiscourseURL(issueURL, topicURL)
+		if err := j.markDone(issueURL, discourseDone); err != nil {
+			return "", err
+		}
+	}
+	if c != nil {
+		// replies are posted before commenting
+		for _, s := range []step{repliesDone, commentDone} {
+			if j.isDone(issueURL, s) {
+				continue
+			}
+			if err := j.markDone(issueURL, s); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	d := policy.Decision{Action: policy.Close, Rule: "commented without a topic in an earlier run"}
+	if topicURL != "" {
+		d = policy.Decision{Action: policy.Migrate, Rule: "posted to discourse in an earlier run"}
+	}
+	// the step recorded when the topic was posted takes precedence over the
+	// loaded steplib, which journal runs do not load
+	step, isStep := steplib.For(github.IssueRepo(i))
+	if entry.StepID != "" {
+		step, isStep = steplib.Step{ID: entry.StepID}, true
+	}
+	p, err := planStepIssue(ctx, i, d, step, isStep)
+	if err != nil {
+		return "", fmt.Errorf("plan %s: %s", issueURL, err)
+	}
+	p.KeepOpen, p.KeepUnlocked = !entry.closes(o), !entry.locks(o)
+	return p.Classification, execute(ctx, j, i, p)
+}
+
+func classForTopic(topicURL string) string {
+	if topicURL != "" {
+		return classActive
+	}
+	return classStale
+}
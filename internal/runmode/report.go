@@ -0,0 +1,77 @@
+package runmode
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const defaultReportPath = "failure-report.json"
+
+var (
+	continueOnError bool
+	reportPath      string
+	maxFailures     int
+)
+
+func init() {
+	flag.BoolVar(&continueOnError, "continue-on-error", false, "--continue-on-error (record failed issues and move on instead of aborting the live run)")
+	flag.StringVar(&reportPath, "failure-report", defaultReportPath, "--failure-report=<path> (file to write failed issues to when continuing on error)")
+	flag.IntVar(&maxFailures, "max-failures", 0, "--max-failures=<int> (number of failed issues tolerated before the run exits with an error)")
+}
+
+// Failure is an issue whose migration failed at the given step.
+type Failure struct {
+	IssueURL string `json:"issue_url"`
+	Step     string `json:"step"`
+	Error    string `json:"error"`
+}
+
+type stepError struct {
+	step step
+	err  error
+}
+
+func (e stepError) Error() string {
+	return e.err.Error()
+}
+
+func newFailure(issueURL string, err error) Failure {
+	f := Failure{
+		IssueURL: issueURL,
+		Step:     "unknown",
+		Error:    err.Error(),
+	}
+	if se, ok := err.(stepError); ok {
+		f.Step = se.step.String()
+	}
+	return f
+}
+
+func report(failures []Failure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	log.Warnf("%d issues failed:", len(failures))
+	for _, f := range failures {
+		log.Printf("- %s (%s step): %s", f.IssueURL, f.Step, f.Error)
+	}
+
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal failure report: %s", err)
+	}
+	if err := ioutil.WriteFile(reportPath, data, 0644); err != nil {
+		return fmt.Errorf("write failure report %s: %s", reportPath, err)
+	}
+	log.Printf("failure report written to %s", reportPath)
+
+	if len(failures) > maxFailures {
+		return fmt.Errorf("%d issues failed, more than the %d tolerated", len(failures), maxFailures)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package runmode
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+)
+
+const (
+	topicsKeep   = "keep"
+	topicsClose  = "close"
+	topicsDelete = "delete"
+)
+
+var rollbackTopics string
+
+func init() {
+	flag.StringVar(&rollbackTopics, "rollback-topics", topicsKeep, "--rollback-topics=keep|close|delete (what rollback mode does with the discourse topics created by the migration)")
+}
+
+// Rollback undoes the migration of the issues: it unlocks, reopens and
+// deletes the migration comment of each, and keeps, closes or deletes their
+// discourse topic as --rollback-topics says. It rolls back the issues of the
+// journal if fromJournal is set, and the given issues otherwise.
+func Rollback(ctx context.Context, fromJournal bool, issues []*gh.Issue) (Stats, error) {
+	switch rollbackTopics {
+	case topicsKeep, topicsClose, topicsDelete:
+	default:
+		return Stats{}, fmt.Errorf("unknown --rollback-topics %s", rollbackTopics)
+	}
+
+	j, err := openJournal(journalPath)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if !fromJournal && len(issues) == 0 {
+		log.Printf("no issues to roll back")
+		return Stats{}, nil
+	}
+
+	b := batch{ctx: ctx}
+	pool := newPool()
+	pool.runIssues(j, fromJournal, issues, func(issueURL string, get func(ctx context.Context) (*gh.Issue, error)) {
+		b.process(issueURL, func(ctx context.Context) (string, error) {
+			i, err := get(ctx)
+			if err != nil {
+				return "", err
+			}
+			return "", rollback(ctx, j, i)
+		})
+	})
+	pool.wait()
+	return b.finish()
+}
+
+// rollback undoes the migration steps done on the issue, as found in the
+// journal or told by its migration comments, deleting every migration
+// comment, duplicates included.
+func rollback(ctx context.Context, j *journal, i *gh.Issue) error {
+	issueURL := i.GetHTMLURL()
+	comments, markers, err := github.FindMigrationComments(ctx, i)
+	if err != nil {
+		return err
+	}
+
+	topicURL := j.discourseURL(issueURL)
+	for _, m := range markers {
+		if topicURL == "" {
+			topicURL = m.TopicURL
+		}
+	}
+
+	commented := len(comments) > 0
+	if !commented && topicURL == "" && !j.isDone(issueURL, closeDone) && !j.isDone(issueURL, lockDone) {
+		log.Printf("skip %s: not migrated", issueURL)
+		return nil
+	}
+	log.Infof("roll back %s", issueURL)
+
+	if i.GetLocked() && (commented || j.isDone(issueURL, lockDone)) {
+		log.Printf("%s: unlock issue", issueURL)
+		if err := github.Unlock(ctx, i); err != nil {
+			return err
+		}
+	}
+
+	if i.GetState() == "closed" && (commented || j.isDone(issueURL, closeDone)) {
+		log.Printf("%s: reopen issue", issueURL)
+		if err := github.Reopen(ctx, i); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range comments {
+		log.Printf("%s: delete comment %s", issueURL, c.GetHTMLURL())
+		if err := github.DeleteComment(ctx, i, c); err != nil {
+			return err
+		}
+	}
+
+	if topicURL != "" {
+		switch rollbackTopics {
+		case topicsClose:
+			log.Printf("%s: close topic %s", issueURL, topicURL)
+			if err := discourse.CloseTopic(ctx, topicURL); err != nil {
+				return fmt.Errorf("close topic %s: %s", topicURL, err)
+			}
+		case topicsDelete:
+			log.Printf("%s: delete topic %s", issueURL, topicURL)
+			if err := discourse.DeleteTopic(ctx, topicURL); err != nil {
+				return fmt.Errorf("delete topic %s: %s", topicURL, err)
+			}
+		default:
+			log.Printf("%s: keep topic %s", issueURL, topicURL)
+		}
+	}
+
+	return j.forget(issueURL)
+}
@@ -1,105 +1,303 @@
 package runmode
 
 import (
+	"context"
 	"fmt"
 
-
-	"time"
-
-	gh "github.com/google/go-github/github"
 	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
 
-	"github.com/lszucs/github-to-discourse/internal/github"
 	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/manifest"
+	"github.com/lszucs/github-to-discourse/internal/policy"
 )
 
-const (
-	discourseDone = iota
-	commentDone
-	closeDone
-	lockDone
-)
+func DryRun(ctx context.Context, issues []*gh.Issue) (Stats, error) {
+	var stats Stats
+	planned := make([]PlannedIssue, len(issues))
+	errs := make([]error, len(issues))
 
-const (
-	activeTpl = `Hi %s!
-	We are migrating our GitHub issues to Discourse (https://discuss.bitrise.io/c/issues/build-issues).
-	From now on, you can track this issue at: %s`
-	staleTpl  = `Hi %s!
-	We are migrating our GitHub issues to Discourse (https://discuss.bitrise.io/c/issues/build-issues).
-	Because this issue has been inactive for more than three months, we will be closing it.
-	
-	If you feel it is still relevant, please open a ticket on Discourse!`
-)
+	pool := newPool()
+	for idx, i := range issues {
+		idx, i := idx, i
+		pool.run(issueURLRepo(i.GetHTMLURL()), func() {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("process issue %s", i.GetHTMLURL())
+			planned[idx], errs[idx] = decideAndPlan(ctx, i)
+		})
+	}
+	pool.wait()
+	if err := ctx.Err(); err != nil {
+		return stats, fmt.Errorf("interrupted, no plan written: %s", err)
+	}
 
-func DryRun(issues []*gh.Issue) (Stats, error) {
-	var stats Stats
-	for _, i := range issues {
-		log.Printf("process issue %s", i.GetHTMLURL())
-		if i.IsPullRequest() {
+	var plan Plan
+	for idx, i := range issues {
+		if errs[idx] != nil {
+			return stats, fmt.Errorf("plan %s: %s", i.GetHTMLURL(), errs[idx])
+		}
+		p := planned[idx]
+		plan.Issues = append(plan.Issues, p)
+
+		switch p.Classification {
+		case classPullRequest:
 			stats.PullRequest++
 			fmt.Println(fmt.Sprintf("skip %s: is pull request", i.GetHTMLURL()))
-			continue
-		}
-	
-		if !github.IsStale(i) {
+		case classActive:
 			stats.Active++
-			fmt.Println(fmt.Sprintf("%s is active", i.GetHTMLURL()))
-		} else {
+			fmt.Println(fmt.Sprintf("%s is active (%s), %d comments to migrate as replies", i.GetHTMLURL(), p.Rule, len(p.Replies)))
+		case classStale:
 			stats.Stale++
-			fmt.Println(fmt.Sprintf("%s is stale", i.GetHTMLURL()))
+			fmt.Println(fmt.Sprintf("%s is stale (%s)", i.GetHTMLURL(), p.Rule))
+		case classKept:
+			stats.Kept++
+			fmt.Println(fmt.Sprintf("%s is kept open (%s)", i.GetHTMLURL(), p.Rule))
 		}
-		time.Sleep(time.Millisecond + 1000)
 	}
 	stats.Processed = len(issues)
+
+	if err := writePlan(planPath, plan); err != nil {
+		return stats, err
+	}
+	log.Printf("plan written to %s, execute it with --mode=apply", planPath)
 	return stats, nil
 }
 
-func LiveRun(issues []*gh.Issue) (Stats, error) {
-	var stats Stats
+func LiveRun(ctx context.Context, issues []*gh.Issue) (Stats, error) {
+	j, err := openJournal(journalPath)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	b := batch{ctx: ctx}
+	pool := newPool()
 	for _, i := range issues {
-		log.Infof("process issue %s", i.GetHTMLURL())
+		i := i
+		issueURL := i.GetHTMLURL()
 		if i.IsPullRequest() {
-			stats.PullRequest++
-			log.Printf("skip %s: is pull request", i.GetHTMLURL())
+			b.count(func(s *Stats) { s.PullRequest++ })
+			log.Printf("skip %s: is pull request", issueURL)
 			continue
 		}
-	
-		var commentTpl string
-		commentTplParams := []interface{}{i.GetUser().GetLogin()}
-		if !github.IsStale(i) {
-			stats.Active++
-			
-			log.Printf("post to discourse")
-			url, err := discourse.PostTopic(i.GetTitle(), i.GetHTMLURL(), i.GetBody())
+
+		if j.isDone(issueURL, lockDone) {
+			log.Printf("skip %s: already migrated", issueURL)
+			b.count(func(s *Stats) { s.Processed++ })
+			continue
+		}
+
+		pool.run(issueURLRepo(i.GetHTMLURL()), func() {
+			b.process(issueURL, func(ctx context.Context) (string, error) {
+				log.Infof("process issue %s", issueURL)
+				var p PlannedIssue
+				var err error
+				if j.isDone(issueURL, discourseDone) {
+					// an issue posted to discourse in an earlier run stays active even if it went stale since
+					p, err = planIssue(ctx, i, policy.Decision{Action: policy.Migrate, Rule: "posted to discourse in an earlier run"})
+				} else {
+					p, err = decideAndPlan(ctx, i)
+				}
+				if err != nil {
+					return "", fmt.Errorf("plan %s: %s", issueURL, err)
+				}
+				return p.Classification, execute(ctx, j, i, p)
+			})
+		})
+	}
+	pool.wait()
+	return b.finish()
+}
+
+// Apply executes the plan written by a dry run, acting only on the issues
+// listed in it and exactly as planned.
+func Apply(ctx context.Context) (Stats, error) {
+	plan, err := readPlan(planPath)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	j, err := openJournal(journalPath)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	b := batch{ctx: ctx}
+	pool := newPool()
+	for _, p := range plan.Issues {
+		p := p
+		if p.Classification == classPullRequest {
+			b.count(func(s *Stats) { s.PullRequest++ })
+			log.Printf("skip %s: is pull request", p.URL)
+			continue
+		}
+
+		if j.isDone(p.URL, lockDone) {
+			log.Printf("skip %s: already migrated", p.URL)
+			b.count(func(s *Stats) { s.Processed++ })
+			continue
+		}
+
+		pool.run(p.Repo, func() {
+			b.process(p.URL, func(ctx context.Context) (string, error) {
+				log.Infof("process issue %s", p.URL)
+				i, err := fetchPlanned(ctx, p)
+				if err != nil {
+					return "", err
+				}
+				return p.Classification, execute(ctx, j, i, p)
+			})
+		})
+	}
+	pool.wait()
+	return b.finish()
+}
+
+// decideAndPlan plans the issue according to the policy of its repo, which
+// the manifest may override.
+func decideAndPlan(ctx context.Context, i *gh.Issue) (PlannedIssue, error) {
+	if i.IsPullRequest() {
+		return planIssue(ctx, i, policy.Decision{})
+	}
+
+	var d policy.Decision
+	var err error
+	if o := manifest.For(github.IssueRepo(i)); o.Policy != nil {
+		d, err = o.Policy.Decide(ctx, i)
+	} else {
+		d, err = policy.Decide(ctx, i)
+	}
+	if err != nil {
+		return PlannedIssue{}, err
+	}
+	return planIssue(ctx, i, d)
+}
+
+// fetchPlanned fetches the current state of the planned issue.
+func fetchPlanned(ctx context.Context, p PlannedIssue) (*gh.Issue, error) {
+	r, number, err := github.ParseIssueURL(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	if r.String() != p.Repo || number != p.Number {
+		return nil, fmt.Errorf("planned issue %s is not issue %d of %s", p.URL, p.Number, p.Repo)
+	}
+
+	i, err := github.GetIssue(ctx, r.Owner, r.Name, number)
+	if err != nil {
+		return nil, err
+	}
+	if i.GetHTMLURL() != p.URL {
+		return nil, fmt.Errorf("issue %d of %s is %s, not the planned %s", p.Number, p.Repo, i.GetHTMLURL(), p.URL)
+	}
+	return i, nil
+}
+
+// execute runs the planned migration steps of the issue which are not done yet.
+func execute(ctx context.Context, j *journal, i *gh.Issue, p PlannedIssue) error {
+	issueURL := p.URL
+	switch p.Classification {
+	case classActive:
+		log.Printf("%s: post to discourse", issueURL)
+		if err := j.do(issueURL, discourseDone, func() error {
+			url, err := discourse.FindTopic(ctx, issueURL)
 			if err != nil {
-				return stats, err
+				return err
+			}
+			if url != "" {
+				log.Printf("%s: topic already exists: %s", issueURL, url)
+			} else if url, err = discourse.PostTopic(ctx, p.Topic.Title, issueURL, p.Topic.Body, p.Topic.CategoryID, p.Topic.Tags); err != nil {
+				return err
 			}
+			j.setTopic(issueURL, url, p)
+			return nil
+		}); err != nil {
+			return stepError{discourseDone, err}
+		}
 
-			commentTpl = activeTpl
-			commentTplParams = append(commentTplParams, url)
-		} else {
-			log.Printf("skip %s: is stale", i.GetHTMLURL())
-			stats.Stale++
-			commentTpl = staleTpl
+		log.Printf("%s: post comments as replies", issueURL)
+		if err := j.do(issueURL, repliesDone, func() error {
+			return postReplies(ctx, issueURL, j.discourseURL(issueURL), p.Replies)
+		}); err != nil {
+			return stepError{repliesDone, fmt.Errorf("post replies of %s: %s", issueURL, err)}
 		}
-	
-		log.Printf("post comment")
-		if err := github.PostComment(i, fmt.Sprintf(commentTpl, commentTplParams...)); err != nil {
-			return stats, fmt.Errorf("post comment to %s: %s",i.GetHTMLURL(), err)
+	case classStale:
+		log.Printf("skip %s: is stale (%s)", issueURL, p.Rule)
+	case classKept:
+		log.Printf("skip %s: is kept open (%s)", issueURL, p.Rule)
+		return nil
+	default:
+		return fmt.Errorf("unknown classification %s of %s", p.Classification, issueURL)
+	}
+
+	// recorded for stale issues too, before the first step saving the journal
+	j.setClosing(issueURL, p)
+
+	log.Printf("%s: post comment", issueURL)
+	if err := j.do(issueURL, commentDone, func() error {
+		c, _, err := github.FindMigrationComment(ctx, i)
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			log.Printf("%s: comment already exists: %s", issueURL, c.GetHTMLURL())
+			return nil
+		}
+		topicURL := j.discourseURL(issueURL)
+		return github.PostComment(ctx, i, p.comment(topicURL), topicURL)
+	}); err != nil {
+		return stepError{commentDone, fmt.Errorf("post comment to %s: %s", issueURL, err)}
+	}
+
+	log.Printf("%s: close issue", issueURL)
+	if err := j.do(issueURL, closeDone, func() error {
+		if p.KeepOpen {
+			log.Printf("%s: keep issue open", issueURL)
+			return nil
 		}
-		
-		log.Printf("close issue")
-		if err := github.Close(i); err != nil {
-			return stats, fmt.Errorf("close %s: %s",i.GetHTMLURL(), err)
+		if i.GetState() == "closed" {
+			return nil
 		}
-		
-		log.Printf("lock issue")
-		if err := github.Lock(i); err != nil {
-			return stats, fmt.Errorf("lock %s: %s",i.GetHTMLURL(), err)
+		return github.Close(ctx, i)
+	}); err != nil {
+		return stepError{closeDone, fmt.Errorf("close %s: %s", issueURL, err)}
+	}
+
+	log.Printf("%s: lock issue", issueURL)
+	if err := j.do(issueURL, lockDone, func() error {
+		if p.KeepUnlocked {
+			log.Printf("%s: keep issue unlocked", issueURL)
+			return nil
 		}
-	
-		stats.Processed++
-		time.Sleep(time.Millisecond + 1000)
+		if i.GetLocked() {
+			return nil
+		}
+		return github.Lock(ctx, i)
+	}); err != nil {
+		return stepError{lockDone, fmt.Errorf("lock %s: %s", issueURL, err)}
 	}
-	return stats, nil
+
+	return nil
+}
+
+// postReplies posts the planned replies, which were not posted yet, to the
+// discourse topic in their original order.
+func postReplies(ctx context.Context, issueURL, topicURL string, replies []PlannedReply) error {
+	posted, err := discourse.PostedReplies(ctx, topicURL)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range replies {
+		if posted[r.CommentURL] {
+			continue
+		}
+
+		if err := discourse.PostReply(ctx, topicURL, issueURL, r.CommentURL, r.Body); err != nil {
+			return fmt.Errorf("post reply for %s: %s", r.CommentURL, err)
+		}
+	}
+	return nil
 }
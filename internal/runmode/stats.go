@@ -1,9 +1,10 @@
 package runmode
 
-
 type Stats struct {
 	Processed   int
 	Stale       int
 	Active      int
+	Kept        int
 	PullRequest int
-}
\ No newline at end of file
+	Failed      int
+}
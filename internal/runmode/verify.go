@@ -0,0 +1,207 @@
+package runmode
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/manifest"
+	"github.com/lszucs/github-to-discourse/internal/marker"
+)
+
+const defaultVerifyReportPath = "verify-report.json"
+
+var verifyReportPath string
+
+func init() {
+	flag.StringVar(&verifyReportPath, "verify-report", defaultVerifyReportPath, "--verify-report=<path> (file to write the results of verify mode to)")
+}
+
+// Verification is the result of checking a migrated issue.
+type Verification struct {
+	IssueURL string   `json:"issue_url"`
+	TopicURL string   `json:"topic_url,omitempty"`
+	Passed   bool     `json:"passed"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Verify checks that every migrated issue is closed, locked, carries exactly
+// one migration comment, and that its discourse topic, if any, exists in the
+// category it was planned to be posted to, as recorded in the journal, with
+// the issue's URL in its first post. Closing and locking follow the manifest
+// overrides of the issue's repo. It checks the issues of the journal if
+// fromJournal is set, and the given issues otherwise.
+func Verify(ctx context.Context, fromJournal bool, issues []*gh.Issue) (Stats, error) {
+	j, err := openJournal(journalPath)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var mu sync.Mutex
+	var results []Verification
+	check := func(ctx context.Context, i *gh.Issue, issueURL string) {
+		v, migrated := verify(ctx, j, i, issueURL)
+		if !migrated {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, v)
+	}
+
+	if !fromJournal && len(issues) == 0 {
+		log.Printf("no issues to verify")
+		return Stats{}, nil
+	}
+
+	pool := newPool()
+	pool.runIssues(j, fromJournal, issues, func(issueURL string, get func(ctx context.Context) (*gh.Issue, error)) {
+		if ctx.Err() != nil {
+			return
+		}
+		i, err := get(ctx)
+		if err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, Verification{IssueURL: issueURL, Problems: []string{err.Error()}})
+			return
+		}
+		check(ctx, i, issueURL)
+	})
+	pool.wait()
+	if err := ctx.Err(); err != nil {
+		return Stats{}, fmt.Errorf("interrupted, verification incomplete: %s", err)
+	}
+
+	return verifyReport(results)
+}
+
+// verify checks the issue, returning false if it is neither in the journal
+// nor carries a migration comment.
+func verify(ctx context.Context, j *journal, i *gh.Issue, issueURL string) (Verification, bool) {
+	v := Verification{IssueURL: issueURL}
+	entry, inJournal := j.get(issueURL)
+	if i.GetComments() == 0 && !inJournal {
+		return v, false
+	}
+
+	comments, markers, err := github.FindMigrationComments(ctx, i)
+	if err != nil {
+		v.Problems = append(v.Problems, err.Error())
+		return v, true
+	}
+	if len(comments) == 0 && !inJournal {
+		return v, false
+	}
+
+	owner, name := github.IssueRepo(i)
+	// the journal tells how the issue was planned, as journal runs load no manifest
+	o := manifest.For(owner, name)
+	if entry.closes(o) && i.GetState() != "closed" {
+		v.Problems = append(v.Problems, "issue is not closed")
+	}
+	if entry.locks(o) && !i.GetLocked() {
+		v.Problems = append(v.Problems, "issue is not locked")
+	}
+	if len(comments) != 1 {
+		v.Problems = append(v.Problems, fmt.Sprintf("issue has %d migration comments instead of 1", len(comments)))
+	}
+
+	v.TopicURL = entry.DiscourseURL
+	for _, m := range markers {
+		if v.TopicURL == "" {
+			v.TopicURL = m.TopicURL
+		}
+	}
+	if v.TopicURL != "" {
+		// the category recorded when the topic was posted, falling back to
+		// the current configuration for topics the journal does not know
+		categoryID := entry.CategoryID
+		if categoryID == 0 {
+			categoryID = topicCategoryID(owner, name)
+		}
+		v.Problems = append(v.Problems, verifyTopic(ctx, v.TopicURL, issueURL, categoryID)...)
+	}
+
+	v.Passed = len(v.Problems) == 0
+	return v, true
+}
+
+func verifyTopic(ctx context.Context, topicURL, issueURL string, categoryID int) []string {
+	t, found, err := discourse.GetTopic(ctx, topicURL)
+	if err != nil {
+		return []string{fmt.Sprintf("fetch topic %s: %s", topicURL, err)}
+	}
+	if !found {
+		return []string{fmt.Sprintf("topic %s does not exist", topicURL)}
+	}
+
+	var problems []string
+	if t.CategoryID != categoryID {
+		problems = append(problems, fmt.Sprintf("topic %s is in category %d instead of %d", topicURL, t.CategoryID, categoryID))
+	}
+	if !linksURL(marker.Strip(t.FirstPostRaw), issueURL) {
+		problems = append(problems, fmt.Sprintf("first post of topic %s does not link %s", topicURL, issueURL))
+	}
+	return problems
+}
+
+func verifyReport(results []Verification) (Stats, error) {
+	var stats Stats
+	for _, v := range results {
+		stats.Processed++
+		if v.Passed {
+			log.Successf("pass %s", v.IssueURL)
+			continue
+		}
+		stats.Failed++
+		log.Errorf("fail %s:", v.IssueURL)
+		for _, p := range v.Problems {
+			log.Printf("- %s", p)
+		}
+	}
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return stats, fmt.Errorf("marshal verify report: %s", err)
+	}
+	if err := ioutil.WriteFile(verifyReportPath, data, 0644); err != nil {
+		return stats, fmt.Errorf("write verify report %s: %s", verifyReportPath, err)
+	}
+	log.Printf("verify report written to %s", verifyReportPath)
+
+	if stats.Failed > 0 {
+		return stats, fmt.Errorf("%d of %d migrated issues failed verification", stats.Failed, stats.Processed)
+	}
+	return stats, nil
+}
+
+// linksURL tells whether body contains u as a whole, so that it is not
+// mistaken for a link to a longer URL like .../issues/12 for .../issues/1.
+func linksURL(body, u string) bool {
+	for offset := 0; ; {
+		idx := strings.Index(body[offset:], u)
+		if idx < 0 {
+			return false
+		}
+		start, end := offset+idx, offset+idx+len(u)
+		if (start == 0 || !isURLChar(body[start-1])) && (end == len(body) || !isURLChar(body[end])) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+// isURLChar tells whether c continues a URL path rather than ending it.
+func isURLChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_/%~", c) >= 0
+}
@@ -0,0 +1,38 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+
+	"github.com/lszucs/github-to-discourse/internal/github"
+)
+
+// LoadRepos returns the URLs of the repos matching the GitHub search query,
+// such as "org:bitrise-steplib topic:bitrise-step is:public".
+func LoadRepos(ctx context.Context, query string) (repoURLs []string, err error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	repos, err := github.SearchRepos(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("search repos %q: %s", query, err)
+	}
+
+	// results may shift between pages while paginating, listing a repo twice
+	seen := map[string]bool{}
+	for _, r := range repos {
+		key := strings.ToLower(r.GetFullName())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		repoURLs = append(repoURLs, r.GetHTMLURL())
+	}
+	log.Printf("search %q matched %d repos", query, len(repoURLs))
+
+	return repoURLs, nil
+}
@@ -2,18 +2,256 @@ package steplib
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
-	stepmanModels "github.com/bitrise-io/stepman/models"
 	"github.com/bitrise-io/go-utils/log"
+	stepmanModels "github.com/bitrise-io/stepman/models"
+	"gopkg.in/yaml.v2"
+
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/patterns"
 )
 
-func LoadRepos(steplibURL string, fromOrgs []string) (repoURLs []string, err error) {
+var (
+	excludeDeprecated bool
+	typeTags          string
+	projectTypes      string
+	stepIDs           string
+	categoriesPath    string
+
+	mu    sync.Mutex
+	repos = map[string]Step{}
+)
+
+func init() {
+	flag.BoolVar(&excludeDeprecated, "steplib-exclude-deprecated", false, "--steplib-exclude-deprecated (exclude the steps with deprecation notes or a removal date)")
+	flag.StringVar(&typeTags, "steplib-type-tags", "", "--steplib-type-tags=build,test (only include steps with any of the given type tags)")
+	flag.StringVar(&projectTypes, "steplib-project-types", "", "--steplib-project-types=ios,android (only include steps with any of the given project type tags, or with none)")
+	flag.StringVar(&stepIDs, "steplib-steps", "", "--steplib-steps=xcode-*,gradle-runner (only include steps with an ID matching any of the given globs)")
+	flag.StringVar(&categoriesPath, "steplib-categories", "", "--steplib-categories=<path> (JSON file mapping step IDs to the discourse category ID their issues are posted to)")
+}
+
+// Step is a steplib step whose repo is migrated.
+type Step struct {
+	ID            string
+	LatestVersion string
+	Model         stepmanModels.StepModel
+	// CategoryID is the discourse category of the step's issues, 0 if not configured.
+	CategoryID int
+}
+
+// Title returns the title of the step, or its ID if it has none.
+func (s Step) Title() string {
+	if s.Model.Title != nil && *s.Model.Title != "" {
+		return *s.Model.Title
+	}
+	return s.ID
+}
+
+// RepoURL returns the git URL of the step's repo.
+func (s Step) RepoURL() string {
+	if s.Model.Source == nil {
+		return ""
+	}
+	return s.Model.Source.Git
+}
+
+// LoadSteps returns the steps of the steplibs owned by the given orgs and
+// passing the --steplib-* filters, logging why each step is included or
+// excluded, and registers them by their repo. A source is either the URL of
+// a steplib spec.json, the path of a local spec.json, or the path of a local
+// clone of a steplib git repo. The steps of multiple sources are merged, a
+// step of an earlier source taking precedence over a step of the same ID of
+// a later source.
+func LoadSteps(sources []string, fromOrgs []string) ([]Step, error) {
+	categories, err := loadCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	steps := stepmanModels.StepHash{}
+	for _, src := range sources {
+		srcSteps, err := loadSource(src)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("loaded %d steps from %s", len(srcSteps), src)
+
+		for id, stp := range srcSteps {
+			if _, ok := steps[id]; ok {
+				log.Warnf("step %s of %s is already loaded from an earlier steplib, skipping", id, src)
+				continue
+			}
+			steps[id] = stp
+		}
+	}
+
+	var ids []string
+	for id := range steps {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var included []Step
+	for _, id := range ids {
+		stp := steps[id]
+		source := gitSource(stp)
+		if reason := exclude(id, stp, fromOrgs); reason != "" {
+			log.Printf("exclude step %s (%s): %s", id, source, reason)
+			continue
+		}
+		log.Printf("include step %s (%s)", id, source)
+
+		step := Step{
+			ID:            id,
+			LatestVersion: stp.LatestVersionNumber,
+			Model:         stp.Versions[stp.LatestVersionNumber],
+			CategoryID:    categories[id],
+		}
+		included = append(included, step)
+
+		// exclude made sure the source parses
+		repo, _ := github.ParseRepo(source)
+		if other, ok := repos[repo.Key()]; ok {
+			log.Warnf("step %s shares its repo %s with step %s, its issues are migrated as %s", id, repo, other.ID, other.ID)
+			continue
+		}
+		repos[repo.Key()] = step
+	}
+
+	return included, nil
+}
+
+// For returns the loaded step of the repo.
+func For(owner, name string) (Step, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	s, ok := repos[github.Repo{Owner: owner, Name: name}.Key()]
+	return s, ok
+}
+
+func loadCategories() (map[string]int, error) {
+	categories := map[string]int{}
+	if categoriesPath == "" {
+		return categories, nil
+	}
+
+	data, err := ioutil.ReadFile(categoriesPath)
+	if err != nil {
+		return nil, fmt.Errorf("read step categories %s: %s", categoriesPath, err)
+	}
+	if err := json.Unmarshal(data, &categories); err != nil {
+		return nil, fmt.Errorf("unmarshal step categories %s: %s", categoriesPath, err)
+	}
+	return categories, nil
+}
+
+// exclude returns why the step is filtered out, or an empty string if it is included.
+func exclude(id string, stp stepmanModels.StepGroupModel, fromOrgs []string) string {
+	latest, ok := stp.LatestVersion()
+	if !ok {
+		return fmt.Sprintf("latest version %s not found", stp.LatestVersionNumber)
+	}
+	if latest.Source == nil {
+		return "no source"
+	}
+
+	repo, err := github.ParseRepo(latest.Source.Git)
+	if err != nil {
+		return err.Error()
+	}
+	if !containsFold(fromOrgs, repo.Owner) {
+		return fmt.Sprintf("owner %s is not one of %s", repo.Owner, strings.Join(fromOrgs, ", "))
+	}
+
+	if excludeDeprecated {
+		if stp.Info.RemovalDate != "" {
+			return fmt.Sprintf("deprecated, removal date %s", stp.Info.RemovalDate)
+		}
+		if stp.Info.DeprecateNotes != "" {
+			return fmt.Sprintf("deprecated: %s", stp.Info.DeprecateNotes)
+		}
+	}
+
+	if globs := patterns.Split(stepIDs); len(globs) > 0 && !patterns.MatchesAny(id, globs) {
+		return fmt.Sprintf("step id matches none of %s", strings.Join(globs, ", "))
+	}
+
+	if tags := patterns.Split(typeTags); len(tags) > 0 && !hasAny(latest.TypeTags, tags) {
+		return fmt.Sprintf("type tags [%s] include none of %s", strings.Join(latest.TypeTags, ", "), strings.Join(tags, ", "))
+	}
+
+	// steps without project type tags support every project type
+	if tags := patterns.Split(projectTypes); len(tags) > 0 && len(latest.ProjectTypeTags) > 0 && !hasAny(latest.ProjectTypeTags, tags) {
+		return fmt.Sprintf("project type tags [%s] include none of %s", strings.Join(latest.ProjectTypeTags, ", "), strings.Join(tags, ", "))
+	}
+	return ""
+}
+
+// gitSource returns the git URL of the latest version of the step, if any.
+func gitSource(stp stepmanModels.StepGroupModel) string {
+	latest, ok := stp.LatestVersion()
+	if !ok || latest.Source == nil {
+		return ""
+	}
+	return latest.Source.Git
+}
+
+func hasAny(have, wanted []string) bool {
+	for _, h := range have {
+		if containsFold(wanted, h) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(items []string, s string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSource loads the steps of the steplib source.
+func loadSource(src string) (stepmanModels.StepHash, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return fetchSpec(src)
+	}
+
+	pth := strings.TrimPrefix(src, "file://")
+	info, err := os.Stat(pth)
+	if err != nil {
+		return nil, fmt.Errorf("open steplib %s: %s", src, err)
+	}
+	if info.IsDir() {
+		return readCheckout(pth)
+	}
+
+	sp, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("read steplib json %s: %s", pth, err)
+	}
+	return parseSpec(sp)
+}
+
+// fetchSpec downloads the spec.json of a steplib.
+func fetchSpec(specURL string) (stepmanModels.StepHash, error) {
 	// get spec file
-	resp, err := http.Get(steplibURL)
+	resp, err := http.Get(specURL)
 	if err != nil {
 		return nil, fmt.Errorf("fetch steplib json: %s", err)
 	}
@@ -23,34 +261,100 @@ func LoadRepos(steplibURL string, fromOrgs []string) (repoURLs []string, err err
 		}
 	}()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch steplib json %s: %s", specURL, resp.Status)
+	}
+
 	// read spec file
 	sp, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("read steplib json: %s", err)
 	}
+	return parseSpec(sp)
+}
 
+func parseSpec(sp []byte) (stepmanModels.StepHash, error) {
 	// unmarshal spec file
 	var data stepmanModels.StepCollectionModel
 	if err := json.Unmarshal(sp, &data); err != nil {
 		return nil, fmt.Errorf("unmarshal steplib json %s: %s", string(sp), err)
 	}
+	return data.Steps, nil
+}
+
+// readCheckout reads the steps of a local clone of a steplib git repo, laid
+// out as steps/<step id>/<version>/step.yml, with the optional step group
+// info in steps/<step id>/step-info.yml.
+func readCheckout(dir string) (stepmanModels.StepHash, error) {
+	stepYMLs, err := filepath.Glob(filepath.Join(dir, "steps", "*", "*", "step.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("list steps of %s: %s", dir, err)
+	}
+	if len(stepYMLs) == 0 {
+		return nil, fmt.Errorf("no steps/*/*/step.yml in %s, not a steplib checkout", dir)
+	}
 
-	// process steps
-	for _, stp := range data.Steps {
-		// filter to our repositories
-		for _, o := range fromOrgs {
-			parts := strings.SplitAfter(stp.Versions[stp.LatestVersionNumber].Source.Git, "github.com/")
-			if len(parts) == 1 {
-				continue
-			}
+	steps := stepmanModels.StepHash{}
+	for _, pth := range stepYMLs {
+		versionDir := filepath.Dir(pth)
+		stepDir := filepath.Dir(versionDir)
+		id, version := filepath.Base(stepDir), filepath.Base(versionDir)
 
-			owner := strings.Split(parts[1], "/")[0]
-			if owner == o {
-				repoURLs = append(repoURLs, stp.Versions[stp.LatestVersionNumber].Source.Git)
-				break
+		var stp stepmanModels.StepModel
+		if err := readYML(pth, &stp); err != nil {
+			return nil, err
+		}
+
+		group, ok := steps[id]
+		if !ok {
+			group.Versions = map[string]stepmanModels.StepModel{}
+			if err := readYML(filepath.Join(stepDir, "step-info.yml"), &group.Info); err != nil && !os.IsNotExist(err) {
+				return nil, err
 			}
 		}
+		group.Versions[version] = stp
+		steps[id] = group
 	}
 
-	return repoURLs, nil
+	for id, group := range steps {
+		var versions []string
+		for v := range group.Versions {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+		group.LatestVersionNumber = versions[len(versions)-1]
+		steps[id] = group
+	}
+	return steps, nil
+}
+
+// readYML unmarshals the YAML file at pth into v, returning the os error as
+// is if the file cannot be read.
+func readYML(pth string, v interface{}) error {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("unmarshal %s: %s", pth, err)
+	}
+	return nil
+}
+
+// versionLess compares dot separated version numbers numerically, falling
+// back to comparing as strings for parts which are not numbers.
+func versionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			return an < bn
+		}
+		return as[i] < bs[i]
+	}
+	return len(as) < len(bs)
 }
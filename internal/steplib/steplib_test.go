@@ -0,0 +1,28 @@
+package steplib
+
+import "testing"
+
+func TestVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "1.0.0", b: "1.0.1", want: true},
+		{a: "1.0.1", b: "1.0.0", want: false},
+		{a: "1.0.0", b: "1.0.0", want: false},
+		{a: "1.9.0", b: "1.10.0", want: true},
+		{a: "1.10.0", b: "1.9.0", want: false},
+		{a: "2.0.0", b: "10.0.0", want: true},
+		{a: "1.0", b: "1.0.0", want: true},
+		{a: "1.0.0", b: "1.0", want: false},
+		{a: "1.0.0-beta", b: "1.0.0-rc", want: true},
+		{a: "1.x.0", b: "1.0.0", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" < "+tt.b, func(t *testing.T) {
+			if got := versionLess(tt.a, tt.b); got != tt.want {
+				t.Errorf("versionLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
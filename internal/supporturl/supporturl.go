@@ -0,0 +1,196 @@
+package supporturl
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/bitrise-io/go-utils/log"
+
+	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/steplib"
+)
+
+const (
+	stepYML = "step.yml"
+
+	defaultFormat = "{{.DiscourseURL}}/tag/{{.StepID}}"
+	defaultBranch = "discourse-support-url"
+)
+
+var (
+	format  string
+	branch  string
+	openPRs bool
+
+	supportURLLine = regexp.MustCompile(`(?m)^support_url:.*$`)
+)
+
+func init() {
+	flag.StringVar(&format, "support-url-format", defaultFormat, "--support-url-format=<template> (text/template of the discourse support URL of a step, with .DiscourseURL, .StepID and .CategoryID)")
+	flag.StringVar(&branch, "support-url-branch", defaultBranch, "--support-url-branch=<name> (branch the support URL change is pushed to in the step repos)")
+	flag.BoolVar(&openPRs, "open-prs", false, "--open-prs (push the support URL changes and open pull requests, otherwise only print the diffs)")
+}
+
+// Data is what the support URL template can refer to.
+type Data struct {
+	DiscourseURL string
+	StepID       string
+	CategoryID   int
+}
+
+// Update rewrites the support_url of the step.yml of every step which points
+// at GitHub issues to its discourse URL, skipping and reporting the steps
+// whose discourse URL does not exist. It prints the diffs, and with
+// --open-prs pushes them to a branch of the step repo and opens a pull request.
+func Update(ctx context.Context, steps []steplib.Step) error {
+	tpl, err := template.New("support-url").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return fmt.Errorf("parse --support-url-format: %s", err)
+	}
+
+	var failed, missing []string
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("interrupted: %s", err)
+		}
+
+		if s.Model.SupportURL == nil || !isIssuesURL(*s.Model.SupportURL) {
+			log.Printf("skip step %s: support url is not GitHub issues", s.ID)
+			continue
+		}
+
+		data := Data{DiscourseURL: discourse.BaseURL(), StepID: s.ID, CategoryID: s.CategoryID}
+		if data.CategoryID == 0 {
+			data.CategoryID = discourse.CategoryID()
+		}
+		var b bytes.Buffer
+		if err := tpl.Execute(&b, data); err != nil {
+			return fmt.Errorf("render support url of %s: %s", s.ID, err)
+		}
+
+		// discourse cuts tags to its max_tag_length, so the tag page of a
+		// step with a long ID may not exist
+		exists, err := discourse.PageExists(ctx, b.String())
+		if err != nil {
+			log.Errorf("failed to check support url %s of step %s: %s", b.String(), s.ID, err)
+			failed = append(failed, s.ID)
+			continue
+		}
+		if !exists {
+			log.Warnf("skip step %s: support url %s does not exist", s.ID, b.String())
+			missing = append(missing, s.ID)
+			continue
+		}
+
+		if err := update(ctx, s, b.String()); err != nil {
+			log.Errorf("failed to update support url of step %s: %s", s.ID, err)
+			failed = append(failed, s.ID)
+		}
+	}
+
+	if len(missing) > 0 {
+		log.Warnf("skipped %d steps whose support url does not exist: %s", len(missing), strings.Join(missing, ", "))
+	}
+	if len(failed) > 0 || len(missing) > 0 {
+		return fmt.Errorf("failed to update the support url of %d steps: %s", len(failed)+len(missing), strings.Join(append(failed, missing...), ", "))
+	}
+	return nil
+}
+
+// isIssuesURL tells whether u is the issues page of a GitHub repo.
+func isIssuesURL(u string) bool {
+	if _, err := github.ParseRepo(u); err != nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(u), "/issues")
+}
+
+func update(ctx context.Context, s steplib.Step, supportURL string) error {
+	repo, err := github.ParseRepo(s.RepoURL())
+	if err != nil {
+		return err
+	}
+	base, err := github.DefaultBranch(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	content, sha, err := github.GetFile(ctx, repo, stepYML, base)
+	if err != nil {
+		return err
+	}
+	updated, diff, ok := rewrite(content, supportURL)
+	if !ok {
+		log.Printf("skip step %s: no support_url in %s of %s", s.ID, stepYML, repo)
+		return nil
+	}
+	if updated == content {
+		log.Printf("skip step %s: support url already points to %s", s.ID, supportURL)
+		return nil
+	}
+	fmt.Printf("--- a/%s (%s@%s)\n+++ b/%s\n%s", stepYML, repo, base, stepYML, diff)
+
+	if !openPRs {
+		return nil
+	}
+
+	pr, err := github.FindOpenPR(ctx, repo, branch)
+	if err != nil {
+		return err
+	}
+	if pr != nil {
+		log.Printf("skip step %s: pull request already open: %s", s.ID, pr.GetHTMLURL())
+		return nil
+	}
+
+	created, err := github.CreateBranch(ctx, repo, branch, base)
+	if err != nil {
+		return err
+	}
+	if !created {
+		// left behind by an earlier run, which may have pushed the change already
+		if content, sha, err = github.GetFile(ctx, repo, stepYML, branch); err != nil {
+			return err
+		}
+		updated, _, _ = rewrite(content, supportURL)
+	}
+	if updated != content {
+		message := fmt.Sprintf("Point support_url to %s", supportURL)
+		if err := github.UpdateFile(ctx, repo, stepYML, branch, message, updated, sha); err != nil {
+			return err
+		}
+	}
+
+	body := fmt.Sprintf("The issues of this step moved to Discourse, so `support_url` in `%s` points to %s instead of %s.", stepYML, supportURL, *s.Model.SupportURL)
+	pr, err = github.OpenPR(ctx, repo, "Move support to Discourse", body, branch, base)
+	if err != nil {
+		return err
+	}
+	log.Successf("step %s: opened %s", s.ID, pr.GetHTMLURL())
+	return nil
+}
+
+// rewrite replaces the support_url of the step.yml content, returning the
+// new content and the diff of the change, or false if it has no support_url.
+func rewrite(content, supportURL string) (string, string, bool) {
+	loc := supportURLLine.FindStringIndex(content)
+	if loc == nil {
+		return content, "", false
+	}
+
+	oldLine := strings.TrimSuffix(content[loc[0]:loc[1]], "\r")
+	newLine := "support_url: " + supportURL
+	if oldLine == newLine {
+		return content, "", true
+	}
+
+	line := strings.Count(content[:loc[0]], "\n") + 1
+	diff := fmt.Sprintf("@@ -%d +%d @@\n-%s\n+%s\n", line, line, oldLine, newLine)
+	return content[:loc[0]] + newLine + content[loc[0]+len(oldLine):], diff, true
+}
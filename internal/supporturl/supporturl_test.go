@@ -0,0 +1,113 @@
+package supporturl
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	stepmanModels "github.com/bitrise-io/stepman/models"
+
+	"github.com/lszucs/github-to-discourse/internal/steplib"
+)
+
+const discourseURL = "https://discuss.bitrise.io/tag/xcode-test"
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     string
+		wantDiff string
+		wantOK   bool
+	}{
+		{
+			name:     "issues url",
+			content:  "title: Xcode Test\nsupport_url: https://github.com/bitrise-steplib/steps-xcode-test/issues\nsource_code_url: https://github.com/bitrise-steplib/steps-xcode-test\n",
+			want:     "title: Xcode Test\nsupport_url: " + discourseURL + "\nsource_code_url: https://github.com/bitrise-steplib/steps-xcode-test\n",
+			wantDiff: "@@ -2 +2 @@\n-support_url: https://github.com/bitrise-steplib/steps-xcode-test/issues\n+support_url: " + discourseURL + "\n",
+			wantOK:   true,
+		},
+		{
+			name:     "crlf line endings kept",
+			content:  "title: Xcode Test\r\nsupport_url: https://github.com/bitrise-steplib/steps-xcode-test/issues\r\n",
+			want:     "title: Xcode Test\r\nsupport_url: " + discourseURL + "\r\n",
+			wantDiff: "@@ -2 +2 @@\n-support_url: https://github.com/bitrise-steplib/steps-xcode-test/issues\n+support_url: " + discourseURL + "\n",
+			wantOK:   true,
+		},
+		{
+			name:    "already rewritten",
+			content: "support_url: " + discourseURL + "\n",
+			want:    "support_url: " + discourseURL + "\n",
+			wantOK:  true,
+		},
+		{
+			name:    "no support url",
+			content: "title: Xcode Test\n  support_url: https://github.com/bitrise-steplib/steps-xcode-test/issues\n",
+			want:    "title: Xcode Test\n  support_url: https://github.com/bitrise-steplib/steps-xcode-test/issues\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, diff, ok := rewrite(tt.content, discourseURL)
+			if got != tt.want || diff != tt.wantDiff || ok != tt.wantOK {
+				t.Errorf("rewrite(%q) = %q, %q, %v, want %q, %q, %v", tt.content, got, diff, ok, tt.want, tt.wantDiff, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestIsIssuesURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{url: "https://github.com/bitrise-steplib/steps-xcode-test/issues", want: true},
+		{url: "https://github.com/bitrise-steplib/steps-xcode-test/Issues/", want: true},
+		{url: "https://github.com/bitrise-steplib/steps-xcode-test", want: false},
+		{url: "https://gitlab.com/bitrise-steplib/steps-xcode-test/issues", want: false},
+		{url: "https://discuss.bitrise.io/tag/xcode-test", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := isIssuesURL(tt.url); got != tt.want {
+				t.Errorf("isIssuesURL(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateSkipsMissingSupportURL(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		// discourse cut the tag of the step to 20 characters
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	old := flag.Lookup("discourse-url").Value.String()
+	if err := flag.Set("discourse-url", srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := flag.Set("discourse-url", old); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	issuesURL := "https://github.com/bitrise-steplib/steps-certificate-and-profile-installer/issues"
+	steps := []steplib.Step{{
+		ID:    "certificate-and-profile-installer",
+		Model: stepmanModels.StepModel{SupportURL: &issuesURL},
+	}}
+	err := Update(context.Background(), steps)
+	if err == nil || !strings.Contains(err.Error(), "certificate-and-profile-installer") {
+		t.Errorf("Update() error = %v, want the step reported", err)
+	}
+	if len(requested) != 1 || requested[0] != "/tag/certificate-and-profile-installer" {
+		t.Errorf("requested %v, want only the tag page", requested)
+	}
+}
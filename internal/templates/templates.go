@@ -0,0 +1,180 @@
+package templates
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"text/template"
+	"time"
+
+	gh "github.com/google/go-github/github"
+
+	"github.com/lszucs/github-to-discourse/internal/discourse"
+	"github.com/lszucs/github-to-discourse/internal/github"
+	"github.com/lszucs/github-to-discourse/internal/policy"
+)
+
+const (
+	Active = "active"
+	Stale  = "stale"
+	Topic  = "topic"
+
+	defaultActive = `Hi {{.Author}}!
+We are migrating our GitHub issues to Discourse ({{discourseBaseURL}}/c/issues/build-issues).
+From now on, you can track this issue at: {{.DiscourseURL}}`
+	defaultStale = `Hi {{.Author}}!
+We are migrating our GitHub issues to Discourse ({{discourseBaseURL}}/c/issues/build-issues).
+{{if and .Rule (ne .Rule "` + policy.InactiveRule + `")}}Because of our migration policy ({{.Rule}}), we will be closing this issue.{{else}}Because this issue has been inactive for more than three months, we will be closing it.{{end}}
+
+If you feel it is still relevant, please open a ticket on Discourse!`
+	defaultTopic = `Original GitHub post: {{.URL}}
+
+{{.Body}}`
+)
+
+var (
+	paths = map[string]*string{
+		Active: new(string),
+		Stale:  new(string),
+		Topic:  new(string),
+	}
+	defaults = map[string]string{
+		Active: defaultActive,
+		Stale:  defaultStale,
+		Topic:  defaultTopic,
+	}
+
+	loadOnce sync.Once
+	loadErr  error
+	loaded   map[string]*template.Template
+
+	filesMu sync.Mutex
+	files   = map[string]*template.Template{}
+
+	// funcs are the functions templates can call besides the builtin ones.
+	funcs = template.FuncMap{
+		"discourseBaseURL": discourse.BaseURL,
+	}
+)
+
+func init() {
+	flag.StringVar(paths[Active], "active-tpl", "", "--active-tpl=<path> (text/template file of the comment posted on issues migrated to discourse)")
+	flag.StringVar(paths[Stale], "stale-tpl", "", "--stale-tpl=<path> (text/template file of the comment posted on stale issues)")
+	flag.StringVar(paths[Topic], "topic-tpl", "", "--topic-tpl=<path> (text/template file of the discourse topic body)")
+}
+
+// Data is what templates can refer to.
+type Data struct {
+	Title        string
+	Body         string
+	Author       string
+	URL          string
+	Labels       []string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Repo         string
+	DiscourseURL string
+	// StepID is the ID of the steplib step the repo belongs to, if known.
+	StepID string
+	// Rule is the name of the policy rule which decided what happens to the issue.
+	Rule string
+}
+
+func NewData(i *gh.Issue) Data {
+	owner, name := github.IssueRepo(i)
+	d := Data{
+		Title:     i.GetTitle(),
+		Body:      i.GetBody(),
+		Author:    i.GetUser().GetLogin(),
+		URL:       i.GetHTMLURL(),
+		CreatedAt: i.GetCreatedAt(),
+		UpdatedAt: i.GetUpdatedAt(),
+		Repo:      fmt.Sprintf("%s/%s", owner, name),
+	}
+	for _, l := range i.Labels {
+		d.Labels = append(d.Labels, l.GetName())
+	}
+	return d
+}
+
+func load() {
+	loaded = map[string]*template.Template{}
+	for name, path := range paths {
+		text := defaults[name]
+		if *path != "" {
+			content, err := ioutil.ReadFile(*path)
+			if err != nil {
+				loadErr = fmt.Errorf("read %s template %s: %s", name, *path, err)
+				return
+			}
+			text = string(content)
+		}
+
+		tpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			loadErr = fmt.Errorf("parse %s template: %s", name, err)
+			return
+		}
+		loaded[name] = tpl
+	}
+}
+
+// Render renders the named template with data, loading the templates on first use.
+func Render(name string, data Data) (string, error) {
+	loadOnce.Do(load)
+	if loadErr != nil {
+		return "", loadErr
+	}
+
+	tpl, ok := loaded[name]
+	if !ok {
+		return "", fmt.Errorf("unknown template %s", name)
+	}
+
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s template: %s", name, err)
+	}
+	return b.String(), nil
+}
+
+// RenderFile renders the text/template file at path in place of the named
+// template, or the named template itself if path is empty. Files are parsed
+// on first use.
+func RenderFile(name, path string, data Data) (string, error) {
+	if path == "" {
+		return Render(name, data)
+	}
+
+	tpl, err := parseFile(name, path)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render %s template %s: %s", name, path, err)
+	}
+	return b.String(), nil
+}
+
+func parseFile(name, path string) (*template.Template, error) {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+	if tpl, ok := files[path]; ok {
+		return tpl, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s template %s: %s", name, path, err)
+	}
+	tpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s template %s: %s", name, path, err)
+	}
+	files[path] = tpl
+	return tpl, nil
+}
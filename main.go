@@ -1,44 +1,78 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bitrise-io/go-utils/log"
+	gh "github.com/google/go-github/github"
+	"github.com/lszucs/github-to-discourse/internal/discourse"
 	"github.com/lszucs/github-to-discourse/internal/github"
-	"github.com/lszucs/github-to-discourse/internal/steplib"
+	"github.com/lszucs/github-to-discourse/internal/manifest"
+	"github.com/lszucs/github-to-discourse/internal/org"
 	"github.com/lszucs/github-to-discourse/internal/runmode"
+	"github.com/lszucs/github-to-discourse/internal/search"
+	"github.com/lszucs/github-to-discourse/internal/steplib"
+	"github.com/lszucs/github-to-discourse/internal/supporturl"
 )
 
 const (
-	defaultMode = "dry"
+	defaultMode    = "dry"
 	defaultRepoSrc = "cherry"
-	defaultOrgs = "bitrise-steplib,bitrise-io,bitrise-community"
+	defaultOrgs    = "bitrise-steplib,bitrise-io,bitrise-community"
 )
 
 var (
-	mode   string
+	mode    string
 	repoSrc string
-	orgs   string
+	orgs    string
 )
 
 func init() {
-	flag.StringVar(&mode, "mode", defaultMode, "--mode=dry|live (dry: only prints what would happen, but modifies nothing)")
-	flag.StringVar(&repoSrc, "repo-src", defaultRepoSrc, "--repo-src=cherry|steplib (repo loader to use to process arguments)")
+	flag.StringVar(&mode, "mode", defaultMode, "--mode=dry|live|apply|rollback|verify|repair|support-url|preview (dry: only prints what would happen and writes a plan, but modifies nothing; apply: executes the plan of a dry run; rollback: undoes the migration of the journal's or the given repos' issues; verify: checks the migration of the journal's or the given repos' issues; repair: completes the partial migration of the journal's or the given repos' issues; support-url: prints, or with --open-prs opens pull requests of, the step.yml changes pointing the support_url of the steplib's steps to discourse; preview: renders the templates for the given issue url)")
+	flag.StringVar(&repoSrc, "repo-src", defaultRepoSrc, "--repo-src=cherry|steplib|org|file|search (repo loader to use to process arguments; steplib: the comma separated steplib spec.json URLs, spec.json paths or steplib checkouts given; org: lists the repos of the comma separated orgs given; file: reads the repos and their overrides from the YAML or JSON manifest given; search: the repos matching the GitHub search query given)")
 	flag.StringVar(&orgs, "orgs", defaultOrgs, "--orgs=bitrise-steplib,bitrise-io (filters step repos to those owned by given orgs)")
 }
 
-func getRepoURLs(repoSrc string, srcStr string) ([]string, error) {
+func getRepoURLs(ctx context.Context, repoSrc string, srcStr string) ([]string, error) {
 	var repoURLs []string
 	var err error
 	switch repoSrc {
 	case "steplib":
 		fromOrgs := strings.Split(orgs, ",")
-		repoURLs, err = steplib.LoadRepos(srcStr, fromOrgs)
+		steps, err := steplib.LoadSteps(strings.Split(srcStr, ","), fromOrgs)
+		if err != nil {
+			return nil, fmt.Errorf("load repos from steplib: %s", err)
+		}
+		for _, s := range steps {
+			repoURLs = append(repoURLs, s.RepoURL())
+		}
+
+		return repoURLs, nil
+	case "org":
+		repoURLs, err = org.LoadRepos(ctx, strings.Split(srcStr, ","))
 		if err != nil {
-			return nil, fmt.Errorf("load repos from steplib: %s")
+			return nil, fmt.Errorf("load repos of orgs: %s", err)
+		}
+
+		return repoURLs, nil
+	case "file":
+		repoURLs, err = manifest.Load(srcStr)
+		if err != nil {
+			return nil, fmt.Errorf("load repos from manifest: %s", err)
+		}
+
+		return repoURLs, nil
+	case "search":
+		repoURLs, err = search.LoadRepos(ctx, srcStr)
+		if err != nil {
+			return nil, fmt.Errorf("load repos from search: %s", err)
 		}
 
 		return repoURLs, nil
@@ -49,44 +83,192 @@ func getRepoURLs(repoSrc string, srcStr string) ([]string, error) {
 	}
 }
 
-func main() {
-
-	flag.Parse()
-	
+// loadRepos loads the repo URLs of the repo source, normalizing them and
+// dropping duplicates.
+func loadRepos(ctx context.Context) ([]github.Repo, error) {
 	if len(flag.Args()) == 0 {
-		log.Errorf("error: no repo source url specified")
-		os.Exit(1)
+		return nil, fmt.Errorf("no repo source url specified")
 	}
 
 	log.Infof("get repos")
-	repoURLs, err := getRepoURLs(repoSrc, flag.Args()[0])
+	repoURLs, err := getRepoURLs(ctx, repoSrc, flag.Args()[0])
 	if err != nil {
-		log.Errorf("error getting repos using mode %s and arg %s: %s", repoSrc, flag.Args()[0], err)
-		os.Exit(1)
+		return nil, fmt.Errorf("get repos using mode %s and arg %s: %s", repoSrc, flag.Args()[0], err)
+	}
+	repos, err := github.ParseRepos(repoURLs)
+	if err != nil {
+		return nil, err
+	}
+	if dropped := len(repoURLs) - len(repos); dropped > 0 {
+		log.Printf("dropped %d duplicate repos", dropped)
+	}
+	log.Printf("loaded %d repos: %s", len(repos), repos)
+	return repos, nil
+}
+
+// loadIssues fetches the open issues of the repos, returning the number of
+// repos whose issues could not be listed along with the issues of the rest.
+func loadIssues(ctx context.Context) ([]*gh.Issue, int, error) {
+	repos, err := loadRepos(ctx)
+	if err != nil {
+		return nil, 0, err
 	}
-	log.Printf("loaded %d repos: %s", len(repoURLs), repoURLs)
-	
+
 	log.Infof("get open issues")
-	issues := github.GetOpenIssues(repoURLs)
+	issues, counts, err := github.GetOpenIssues(ctx, repos)
+	if err != nil {
+		return nil, 0, fmt.Errorf("interrupted while fetching issues: %s", err)
+	}
+	failedRepos := 0
+	for _, c := range counts {
+		switch {
+		case c.Err != nil:
+			failedRepos++
+			log.Errorf("%s: failed to fetch open issues: %s", c.Repo, c.Err)
+		case c.Expected < 0:
+			log.Warnf("%s: fetched %d open issues, could not verify against repo count", c.Repo, c.Fetched)
+		case c.Fetched != c.Expected:
+			log.Warnf("%s: fetched %d open issues, repo reports %d", c.Repo, c.Fetched, c.Expected)
+		default:
+			log.Printf("%s: fetched %d/%d open issues", c.Repo, c.Fetched, c.Expected)
+		}
+	}
 	log.Printf("found %d open issues: %s", len(issues), github.GetHTMLURLs(issues))
 
+	return issues, failedRepos, nil
+}
+
+func preview(ctx context.Context) error {
+	if len(flag.Args()) == 0 {
+		return fmt.Errorf("no issue url specified")
+	}
+
+	i, err := github.GetIssueByURL(ctx, flag.Args()[0])
+	if err != nil {
+		return err
+	}
+	return runmode.Preview(ctx, i)
+}
+
+// supportURL updates the support_url of the steps of the steplib source.
+func supportURL(ctx context.Context) error {
+	if repoSrc != "steplib" {
+		return fmt.Errorf("support-url mode needs --repo-src=steplib, not %s", repoSrc)
+	}
+	if len(flag.Args()) == 0 {
+		return fmt.Errorf("no steplib source specified")
+	}
+
+	steps, err := steplib.LoadSteps(strings.Split(flag.Args()[0], ","), strings.Split(orgs, ","))
+	if err != nil {
+		return fmt.Errorf("load steps from steplib: %s", err)
+	}
+	return supporturl.Update(ctx, steps)
+}
+
+// interruptible returns a context which is cancelled on the first SIGINT or
+// SIGTERM, so that runs stop starting new issues but finish the ones in
+// progress. A second signal exits immediately.
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Warnf("interrupted, finishing the issues in progress; interrupt again to exit immediately")
+		cancel()
+		<-signals
+		log.Errorf("exiting immediately")
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+func main() {
+
+	flag.Parse()
+	if err := discourse.CheckCredentials(); err != nil {
+		log.Errorf("error: %s", err)
+		os.Exit(1)
+	}
+	ctx := interruptible()
+
 	var stats runmode.Stats
+	var err error
+	var failedRepos int
 	switch mode {
-	case "dry":
-		stats, err = runmode.DryRun(issues)
-	case "live":
-		stats, err = runmode.LiveRun(issues)
+	case "dry", "live":
+		var issues []*gh.Issue
+		issues, failedRepos, err = loadIssues(ctx)
+		if err != nil {
+			log.Errorf("error: %s", err)
+			os.Exit(1)
+		}
+
+		if mode == "dry" {
+			stats, err = runmode.DryRun(ctx, issues)
+		} else {
+			stats, err = runmode.LiveRun(ctx, issues)
+		}
+	case "apply":
+		stats, err = runmode.Apply(ctx)
+	case "rollback", "verify", "repair":
+		// the journal is only used when no repos are given, never as a
+		// fallback for repos without issues
+		fromJournal := len(flag.Args()) == 0
+		var issues []*gh.Issue
+		if !fromJournal {
+			var repos []github.Repo
+			repos, err = loadRepos(ctx)
+			if err == nil {
+				log.Infof("get issues")
+				issues, err = github.GetAllIssues(ctx, repos)
+			}
+			if err != nil {
+				log.Errorf("error: %s", err)
+				os.Exit(1)
+			}
+		} else {
+			log.Infof("%s issues of journal", mode)
+		}
+		switch mode {
+		case "rollback":
+			stats, err = runmode.Rollback(ctx, fromJournal, issues)
+		case "verify":
+			stats, err = runmode.Verify(ctx, fromJournal, issues)
+		case "repair":
+			stats, err = runmode.Repair(ctx, fromJournal, issues)
+		}
+	case "support-url":
+		if err := supportURL(ctx); err != nil {
+			log.Errorf("error: %s", err)
+			os.Exit(1)
+		}
+		return
+	case "preview":
+		if err := preview(ctx); err != nil {
+			log.Errorf("error: %s", err)
+			os.Exit(1)
+		}
+		return
 	default:
 		log.Errorf("error: unkown run mode %s", mode)
 		os.Exit(1)
 	}
-	
+
+	log.Printf("run stats:")
+	log.Printf("open/pr/stale/migrated/kept/failed: %d/%d/%d/%d/%d/%d ", stats.Processed, stats.PullRequest, stats.Stale, stats.Active, stats.Kept, stats.Failed)
+	if remaining, reset := github.RateLimit(); remaining >= 0 {
+		log.Printf("GitHub core rate limit: %d calls left, resets at %s", remaining, reset.Format(time.RFC3339))
+	}
+
+	if err == nil && failedRepos > 0 {
+		err = fmt.Errorf("could not fetch the open issues of %d repos, they were not processed", failedRepos)
+	}
 	if err != nil {
 		log.Errorf("error: %s", err)
 		os.Exit(1)
 	}
 
 	log.Successf("success!")
-	log.Printf("run stats:")
-	log.Printf("open/pr/stale/migrated: %d/%d/%d/%d ", stats.Processed, stats.PullRequest, stats.Stale, stats.Active)
 }